@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/env"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyHeader                = "X-API-Key"
+	apiKeyPrefix                = "lck"
+	apiKeysTable                = "consumers.api_keys"
+	apiKeyLastUsedTouchInterval = time.Minute
+)
+
+// APIKey is a hashed partner / merchant api key, migrated by the consumers service (only the key hash is stored)
+type APIKey struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Name       string
+	Prefix     string     `gorm:"uniqueIndex;not null"` // public key identifier, used to find the key
+	KeyHash    string     `gorm:"not null"`             // hex encoded sha256 of the key secret
+	ConsumerID uint       `gorm:"index;not null"`       // the merchant / partner consumer the key acts as
+	Scopes     string     // comma separated scopes, e.g. "orders:read,products:*"
+	ExpiresAt  *time.Time // nil for keys that never expire, set to the end of the overlap window when rotated
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// ScopeList returns the key scopes as a slice
+func (k APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// IsActive checks the key is not revoked nor expired
+func (k APIKey) IsActive(now time.Time) bool {
+	return k.ID != 0 && k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// HasScopes checks the key was granted all the given scopes (supporting "*" and "resource:*" wildcards)
+func (k APIKey) HasScopes(scopes ...string) bool {
+	granted := k.ScopeList()
+	for _, scope := range scopes {
+		found := false
+		for _, grantedScope := range granted {
+			if permissionMatches(grantedScope, scope) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateAPIKey creates a new api key for the given consumer, the returned plain key is shown once and can't be recovered
+func CreateAPIKey(db *gorm.DB, name string, consumerId uint, scopes []string, expiresAt *time.Time) (string, APIKey, error) {
+	prefix, err := randomString(6)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	key := APIKey{Name: name, Prefix: prefix, KeyHash: hashAPIKeySecret(secret), ConsumerID: consumerId, Scopes: strings.Join(scopes, ","), ExpiresAt: expiresAt}
+	if err = db.Table(apiKeysTable).Create(&key).Error; err != nil {
+		return "", APIKey{}, errors.Wrap(err, "can't create api key")
+	}
+	return fmt.Sprintf("%v_%v_%v", apiKeyPrefix, prefix, secret), key, nil
+}
+
+// RotateAPIKey creates a replacement key with the same name, consumer and scopes, the old key keeps working for the overlap window
+func RotateAPIKey(db *gorm.DB, id uint, overlap time.Duration) (string, APIKey, error) {
+	var old APIKey
+	if err := db.Table(apiKeysTable).First(&old, id).Error; err != nil {
+		return "", APIKey{}, errors.Wrapf(err, "can't find api key %v", id)
+	}
+
+	var plainKey string
+	var key APIKey
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		plainKey, key, err = CreateAPIKey(tx, old.Name, old.ConsumerID, old.ScopeList(), old.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		overlapEnd := time.Now().Add(overlap)
+		if old.ExpiresAt == nil || overlapEnd.Before(*old.ExpiresAt) {
+			return tx.Table(apiKeysTable).Where("id = ?", old.ID).Update("expires_at", overlapEnd).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return "", APIKey{}, errors.Wrapf(err, "can't rotate api key %v", id)
+	}
+	APIKeyCache.Invalidate(old.Prefix)
+	return plainKey, key, nil
+}
+
+// RevokeAPIKey revokes the given key immediately
+func RevokeAPIKey(db *gorm.DB, id uint) error {
+	var key APIKey
+	if err := db.Table(apiKeysTable).First(&key, id).Error; err != nil {
+		return errors.Wrapf(err, "can't find api key %v", id)
+	}
+	if err := db.Table(apiKeysTable).Where("id = ?", id).Update("revoked_at", time.Now()).Error; err != nil {
+		return errors.Wrapf(err, "can't revoke api key %v", id)
+	}
+	APIKeyCache.Invalidate(key.Prefix)
+	return nil
+}
+
+// RequireAPIKey : to verify the request carries a valid api key (X-API-Key header) with all the given scopes.
+// Populates the same identity as RequireAuth (the key consumer), so existing handlers and ValidateAuthorized work unchanged.
+// Usage: partners.Use(auth.RequireAPIKey("orders:read"))
+func RequireAPIKey(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key, ok := tryGetAPIKey(ctx, ctx.GetHeader(apiKeyHeader))
+		if !ok {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - Invalid api key (%v)", env.GetEnvVar("SERVICE_NAME"))})
+			ctx.Abort()
+			return
+		}
+		if !key.HasScopes(scopes...) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. Api key is missing scopes: %v.", strings.Join(scopes, ","))})
+			ctx.Abort()
+			return
+		}
+
+		SetPrincipal(ctx, &Principal{Realm: APIKeyRealm, ConsumerID: key.ConsumerID, APIKeyID: key.ID, Scopes: key.ScopeList()})
+		ctx.Next()
+	}
+}
+
+// GetAPIKeyScopes returns the scopes of the api key used for the request
+func GetAPIKeyScopes(ctx *gin.Context) []string {
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.Scopes
+	}
+	return nil
+}
+
+func tryGetAPIKey(ctx *gin.Context, plainKey string) (APIKey, bool) {
+	parts := strings.Split(plainKey, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return APIKey{}, false
+	}
+	prefix, secret := parts[1], parts[2]
+
+	key, cached := APIKeyCache.Get(prefix)
+	if !cached {
+		db := ctx.MustGet("DB").(*gorm.DB)
+		err := db.Table(apiKeysTable).Where("prefix = ?", prefix).Limit(1).Find(&key).Error
+		if err != nil {
+			log.Errorf("Got error while getting api key %v: %v", prefix, err)
+			return APIKey{}, false
+		}
+		APIKeyCache.Set(prefix, key)
+	}
+
+	now := time.Now()
+	expectedHash := hashAPIKeySecret(secret)
+	if !key.IsActive(now) || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(expectedHash)) != 1 {
+		return APIKey{}, false
+	}
+	touchAPIKey(ctx, key, prefix, now)
+	return key, true
+}
+
+// touchAPIKey updates the key last used timestamp, at most once per apiKeyLastUsedTouchInterval
+func touchAPIKey(ctx *gin.Context, key APIKey, prefix string, now time.Time) {
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < apiKeyLastUsedTouchInterval {
+		return
+	}
+	db := ctx.MustGet("DB").(*gorm.DB)
+	if err := db.Table(apiKeysTable).Where("id = ?", key.ID).Update("last_used_at", now).Error; err != nil {
+		log.Errorf("Got error while updating last used of api key %v: %v", key.ID, err)
+		return
+	}
+	key.LastUsedAt = &now
+	APIKeyCache.Set(prefix, key)
+}
+
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomString(length int) (string, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.ReplaceAll(base64.RawURLEncoding.EncodeToString(bytes), "_", "-"), nil
+}
@@ -2,34 +2,69 @@ package auth
 
 import "github.com/gin-gonic/gin"
 
+// IsAuthenticated reports whether the request has an authenticated principal (false for anonymous OptionalAuth requests)
+func IsAuthenticated(ctx *gin.Context) bool {
+	_, ok := PrincipalFrom(ctx)
+	return ok
+}
+
+// GetAuthenticatedConsumerId returns the authenticated consumer id, 0 for anonymous requests and non consumers
 func GetAuthenticatedConsumerId(ctx *gin.Context) uint {
-	consumerId, exists := ctx.Get("AUTHENTICATED_CONSUMER_ID")
-	if exists {
-		return consumerId.(uint)
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.ConsumerID
+	}
+	return 0
+}
+
+func GetAuthenticatedBackofficeUserId(ctx *gin.Context) uint {
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.BackofficeUserID
 	}
 	return 0
 }
 
 func GetIsAdmin(ctx *gin.Context) bool {
-	isAdmin, exists := ctx.Get("IS_ADMIN")
-	if exists {
-		return isAdmin.(bool)
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.IsAdmin
 	}
 	return false
 }
 
 func GetAuthenticatedUid(ctx *gin.Context) string {
-	uid, exists := ctx.Get("FIREBASE_USER_UID")
-	if exists {
-		return uid.(string)
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.UID
 	}
 	return ""
 }
 
+// GetIsGuest reports whether the caller is a guest consumer, anonymous requests and non consumers are treated as guests
 func GetIsGuest(ctx *gin.Context) bool {
-	isGuest, exists := ctx.Get("IS_GUEST")
-	if exists {
-		return isGuest.(bool)
+	if p, ok := PrincipalFrom(ctx); ok && p.IsConsumer() {
+		return p.IsGuest
 	}
 	return true
 }
+
+func GetBackofficeRoles(ctx *gin.Context) []string {
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.Roles
+	}
+	return nil
+}
+
+// HasPermission checks if the authenticated backoffice user has the given permission (admins have all permissions)
+func HasPermission(ctx *gin.Context, permission string) bool {
+	p, ok := PrincipalFrom(ctx)
+	if !ok {
+		return false
+	}
+	if p.IsAdmin {
+		return true
+	}
+	for _, grantedPermission := range p.Permissions {
+		if permissionMatches(grantedPermission, permission) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+// Package authtest contains helpers for testing handlers behind the auth middlewares, without firebase or a DB
+// Usage: router.GET("/orders/:id", authtest.Middleware(authtest.Consumer(7)), handler)
+package authtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/let-commerce/backend-common/auth"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const issuer = "authtest"
+
+// Middleware injects the given principal, instead of AuthMiddleware and RequireAuth
+func Middleware(p *auth.Principal) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		copied := *p
+		auth.SetPrincipal(ctx, &copied)
+		ctx.Next()
+	}
+}
+
+// Consumer returns a registered (non guest) consumer principal
+func Consumer(consumerId uint) *auth.Principal {
+	return &auth.Principal{UID: uidOf("consumer", consumerId), Realm: auth.Consumer, ConsumerID: consumerId}
+}
+
+// Guest returns a guest consumer principal
+func Guest(consumerId uint) *auth.Principal {
+	return &auth.Principal{UID: uidOf("guest", consumerId), Realm: auth.Consumer, ConsumerID: consumerId, IsGuest: true}
+}
+
+// Admin returns a platform admin backoffice user principal (with access to every tenant)
+func Admin(backofficeUserId uint) *auth.Principal {
+	return &auth.Principal{UID: uidOf("admin", backofficeUserId), Realm: auth.Backoffice, BackofficeUserID: backofficeUserId, IsAdmin: true, AllTenants: true}
+}
+
+// BackofficeUser returns a non admin backoffice user principal with the given permissions
+func BackofficeUser(backofficeUserId uint, permissions ...string) *auth.Principal {
+	return &auth.Principal{UID: uidOf("backoffice", backofficeUserId), Realm: auth.Backoffice, BackofficeUserID: backofficeUserId, Permissions: permissions}
+}
+
+// Service returns an internal service principal
+func Service(serviceName string) *auth.Principal {
+	return &auth.Principal{UID: serviceName, Realm: auth.Service, ServiceName: serviceName}
+}
+
+func uidOf(kind string, id uint) string {
+	return kind + "-" + strconv.FormatUint(uint64(id), 10)
+}
+
+// Minter signs tokens accepted end to end by AuthMiddleware and RequireAuth of its Authenticator
+type Minter struct {
+	secret   []byte
+	Resolver *Resolver
+}
+
+// NewMinter creates a Minter with a random signing secret and an empty in-memory resolver
+func NewMinter() *Minter {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return &Minter{secret: secret, Resolver: NewResolver()}
+}
+
+// Verifier returns a verifier accepting the tokens of this minter
+func (m *Minter) Verifier() *auth.JWTVerifier {
+	return auth.NewHMACVerifier(m.secret, issuer, "")
+}
+
+// Authenticator returns an Authenticator verifying the tokens of this minter for all realms, resolving users with its Resolver
+func (m *Minter) Authenticator() *auth.Authenticator {
+	if auth.UserIdToConsumerCache == nil {
+		auth.Init()
+	}
+	verifier := m.Verifier()
+	return &auth.Authenticator{
+		Verifiers:              map[auth.RealmName]auth.TokenVerifier{auth.Consumer: verifier, auth.Backoffice: verifier, auth.Service: verifier},
+		ConsumerResolver:       m.Resolver,
+		BackofficeUserResolver: m.Resolver,
+	}
+}
+
+// Token signs a token for the given uid with the given extra claims
+func (m *Minter) Token(t testing.TB, uid string, email string, claims map[string]interface{}) string {
+	t.Helper()
+	mapClaims := jwt.MapClaims{"sub": uid, "iss": issuer, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+	if email != "" {
+		mapClaims["email"] = email
+	}
+	for key, value := range claims {
+		mapClaims[key] = value
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, mapClaims).SignedString(m.secret)
+	if err != nil {
+		t.Fatalf("can't sign test token: %v", err)
+	}
+	return token
+}
+
+// ConsumerToken registers the consumer in the minter resolver and signs a token for it
+func (m *Minter) ConsumerToken(t testing.TB, consumerId uint, isGuest bool) string {
+	uid := uidOf("consumer", consumerId)
+	m.Resolver.AddConsumer(uid, auth.GetConsumerResult{ID: consumerId, IsGuest: isGuest})
+	return m.Token(t, uid, "", nil)
+}
+
+// BackofficeToken registers the backoffice user in the minter resolver (admins as platform admins) and signs a token for it
+func (m *Minter) BackofficeToken(t testing.TB, backofficeUserId uint, isAdmin bool, permissions ...string) string {
+	uid := uidOf("backoffice", backofficeUserId)
+	m.Resolver.AddBackofficeUser(uid, auth.GetBackofficeUserResult{ID: backofficeUserId, IsAdmin: isAdmin, AllTenants: isAdmin, Permissions: permissions})
+	return m.Token(t, uid, "", nil)
+}
+
+// ServiceToken signs a service token for the given service name
+func (m *Minter) ServiceToken(t testing.TB, serviceName string) string {
+	return m.Token(t, serviceName, "", map[string]interface{}{auth.ServiceClaim: serviceName})
+}
+
+// Resolver is an in-memory ConsumerResolver and BackofficeUserResolver, keyed by uid
+type Resolver struct {
+	mutex           sync.RWMutex
+	consumers       map[string]auth.GetConsumerResult
+	backofficeUsers map[string]auth.GetBackofficeUserResult
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{consumers: map[string]auth.GetConsumerResult{}, backofficeUsers: map[string]auth.GetBackofficeUserResult{}}
+}
+
+func (r *Resolver) AddConsumer(uid string, consumer auth.GetConsumerResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.consumers[uid] = consumer
+	auth.Invalidate(uid)
+}
+
+func (r *Resolver) AddBackofficeUser(uid string, backofficeUser auth.GetBackofficeUserResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.backofficeUsers[uid] = backofficeUser
+	auth.Invalidate(uid)
+}
+
+func (r *Resolver) ResolveConsumer(ctx context.Context, identity auth.UserIdentity) (auth.GetConsumerResult, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.consumers[identity.UID], nil
+}
+
+func (r *Resolver) GetConsumer(ctx context.Context, consumerId uint) (auth.GetConsumerResult, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, consumer := range r.consumers {
+		if consumer.ID == consumerId {
+			return consumer, nil
+		}
+	}
+	return auth.GetConsumerResult{}, nil
+}
+
+func (r *Resolver) ResolveBackofficeUser(ctx context.Context, identity auth.UserIdentity) (auth.GetBackofficeUserResult, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.backofficeUsers[identity.UID], nil
+}
+
+func (r *Resolver) GetBackofficeUser(ctx context.Context, backofficeUserId uint) (auth.GetBackofficeUserResult, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, backofficeUser := range r.backofficeUsers {
+		if backofficeUser.ID == backofficeUserId {
+			return backofficeUser, nil
+		}
+	}
+	return auth.GetBackofficeUserResult{}, nil
+}
+
+// Authorize sets the bearer token and the RequestContext header of the given realm on the request
+func Authorize(req *http.Request, token string, realm auth.RealmName) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+token)
+	switch realm {
+	case auth.Backoffice:
+		req.Header.Set("RequestContext", "Backoffice")
+	case auth.Service:
+		req.Header.Set("RequestContext", "Service")
+	}
+	return req
+}
+
+// AssertStatus fails the test if the response status is not the expected one
+func AssertStatus(t testing.TB, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("expected status %v, got %v. body: %v", status, recorder.Code, recorder.Body.String())
+	}
+}
+
+// AssertUnauthorized fails the test if the response is not a 401 error body of the auth package (optionally containing the given text)
+func AssertUnauthorized(t testing.TB, recorder *httptest.ResponseRecorder, contains ...string) {
+	t.Helper()
+	assertErrorBody(t, recorder, http.StatusUnauthorized, contains)
+}
+
+// AssertForbidden fails the test if the response is not a 403 error body of the auth package (optionally containing the given text)
+func AssertForbidden(t testing.TB, recorder *httptest.ResponseRecorder, contains ...string) {
+	t.Helper()
+	assertErrorBody(t, recorder, http.StatusForbidden, contains)
+}
+
+// assertErrorBody checks the status and the error body, either {"error": ...} of the middlewares or the ErrorResponse of ginutils
+func assertErrorBody(t testing.TB, recorder *httptest.ResponseRecorder, status int, contains []string) {
+	t.Helper()
+	AssertStatus(t, recorder, status)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a json error body, got: %v", recorder.Body.String())
+	}
+	errorValue, _ := body["error"].(string)
+	messageValue, _ := body["message"].(string)
+	if errorValue == "" && messageValue == "" {
+		t.Fatalf("expected an error or message in the body, got: %v", recorder.Body.String())
+	}
+	for _, text := range contains {
+		if !strings.Contains(errorValue, text) && !strings.Contains(messageValue, text) {
+			t.Fatalf("expected the error body to contain %q, got: %v", text, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"firebase.google.com/go/auth"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+const backofficeUsersTable = "consumers.back_office_users"
+
+// Client errors of the BackofficeAdmin methods, answered with http.StatusBadRequest / http.StatusConflict by its routes
+var (
+	ErrUnknownBackofficeRole = errors.New("unknown backoffice role")
+	ErrBackofficeUserExists  = errors.New("backoffice user already exists")
+)
+
+// BackOfficeUser is a backoffice staff member, migrated by the consumers service (disabled users are not resolved by an SQLResolver with DisabledUsers)
+type BackOfficeUser struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Email      string `gorm:"uniqueIndex;not null"`
+	IsAdmin    bool
+	DisabledAt *time.Time
+}
+
+// BackofficeAdminClient is the subset of the firebase auth client used to manage backoffice users (implemented by *auth.Client and *auth.TenantClient)
+type BackofficeAdminClient interface {
+	ClaimsUpdater
+	TokenRevoker
+	CreateUser(ctx context.Context, user *auth.UserToCreate) (*auth.UserRecord, error)
+	UpdateUser(ctx context.Context, uid string, user *auth.UserToUpdate) (*auth.UserRecord, error)
+	DeleteUser(ctx context.Context, uid string) error
+	GetUserByEmail(ctx context.Context, email string) (*auth.UserRecord, error)
+	PasswordResetLinkWithSettings(ctx context.Context, email string, settings *auth.ActionCodeSettings) (string, error)
+}
+
+// BackofficeAdmin manages backoffice users in firebase and postgres together
+type BackofficeAdmin struct {
+	Client       BackofficeAdminClient
+	DB           *gorm.DB
+	LinkSettings *auth.ActionCodeSettings // optional continue url of the invite / password reset links (e.g. the backoffice login page)
+	Roles        bool                     // the backoffice users have roles, as in SQLResolver.Roles
+	Tenancy      bool                     // the backoffice users are assigned to tenants, as in SQLResolver.Tenancy (only platform admins may then manage them)
+}
+
+// NewBackofficeAdmin creates a BackofficeAdmin on top of the backoffice firebase client (e.g. BackofficeAuthClient)
+func NewBackofficeAdmin(client BackofficeAdminClient, db *gorm.DB) *BackofficeAdmin {
+	return &BackofficeAdmin{Client: client, DB: db}
+}
+
+type CreateBackofficeUserRequest struct {
+	Email       string   `json:"email" binding:"required,email"`
+	DisplayName string   `json:"displayName"`
+	IsAdmin     bool     `json:"isAdmin"`
+	Roles       []string `json:"roles"`
+}
+
+// CreateUser creates the backoffice user in postgres and firebase (without a password), and returns its invite link.
+// The postgres row is rolled back if the firebase user can't be created.
+func (s *BackofficeAdmin) CreateUser(ctx context.Context, req CreateBackofficeUserRequest) (BackOfficeUser, string, error) {
+	user := BackOfficeUser{Email: req.Email, IsAdmin: req.IsAdmin}
+	var uid string
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Table(backofficeUsersTable).Where("email = ?", req.Email).Count(&count).Error; err != nil {
+			return errors.Wrapf(err, "can't check backoffice user %v", req.Email)
+		}
+		if count > 0 {
+			return errors.Wrapf(ErrBackofficeUserExists, "email %v", req.Email)
+		}
+		if err := tx.Table(backofficeUsersTable).Create(&user).Error; err != nil {
+			return errors.Wrapf(err, "can't create backoffice user %v", req.Email)
+		}
+		if err := assignBackofficeRoles(tx, user.ID, req.Roles); err != nil {
+			return err
+		}
+		userToCreate := (&auth.UserToCreate{}).Email(req.Email)
+		if req.DisplayName != "" {
+			userToCreate = userToCreate.DisplayName(req.DisplayName)
+		}
+		userRecord, err := s.Client.CreateUser(ctx, userToCreate)
+		if auth.IsEmailAlreadyExists(err) {
+			return errors.Wrapf(ErrBackofficeUserExists, "firebase user %v", req.Email)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "can't create firebase user %v", req.Email)
+		}
+		uid = userRecord.UID
+		return nil
+	})
+	if err != nil {
+		if uid != "" { // the firebase user was created but the transaction failed to commit
+			s.deleteFirebaseUser(ctx, uid)
+		}
+		return BackOfficeUser{}, "", err
+	}
+
+	if err = SyncBackofficeUserClaims(ctx, s.Client, uid, user.ID); err != nil {
+		log.Errorf("Got error while syncing claims of backoffice user %v: %v", user.ID, err)
+	}
+	link, err := s.Client.PasswordResetLinkWithSettings(ctx, user.Email, s.LinkSettings)
+	if err != nil {
+		return user, "", errors.Wrapf(err, "can't generate invite link of backoffice user %v", user.ID)
+	}
+	log.Infof("Created backoffice user %v (%v)", user.ID, user.Email)
+	return user, link, nil
+}
+
+// SetDisabled disables (or re-enables) the backoffice user, a disabled user's tokens are revoked.
+// It is no longer resolved by RequireAuth only with an SQLResolver with DisabledUsers (which requires the disabled_at column).
+func (s *BackofficeAdmin) SetDisabled(ctx context.Context, id uint, disabled bool) error {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+
+	uid, err := s.firebaseUid(ctx, user)
+	if err != nil {
+		return err
+	}
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(backofficeUsersTable).Where("id = ?", id).Update("disabled_at", disabledAt).Error; err != nil {
+			return errors.Wrapf(err, "can't update backoffice user %v", id)
+		}
+		if uid == "" { // no firebase user to update
+			return nil
+		}
+		if _, err := s.Client.UpdateUser(ctx, uid, (&auth.UserToUpdate{}).Disabled(disabled)); err != nil {
+			return errors.Wrapf(err, "can't update firebase user %v", uid)
+		}
+		return nil
+	})
+	if err != nil || uid == "" {
+		return err
+	}
+	if disabled {
+		return RevokeUser(ctx, s.Client, uid)
+	}
+	Invalidate(uid)
+	return nil
+}
+
+// DeleteUser deletes the backoffice user (and its role and tenant assignments, when enabled) from postgres and firebase
+func (s *BackofficeAdmin) DeleteUser(ctx context.Context, id uint) error {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	uid, err := s.firebaseUid(ctx, user)
+	if err != nil {
+		return err
+	}
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.deleteAssignments(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Table(backofficeUsersTable).Delete(&BackOfficeUser{}, id).Error; err != nil {
+			return errors.Wrapf(err, "can't delete backoffice user %v", id)
+		}
+		if uid == "" { // no firebase user to delete
+			return nil
+		}
+		if err := s.Client.DeleteUser(ctx, uid); err != nil {
+			return errors.Wrapf(err, "can't delete firebase user %v", uid)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	Invalidate(uid)
+	log.Infof("Deleted backoffice user %v (%v)", user.ID, user.Email)
+	return nil
+}
+
+// AssignRoles replaces the roles of the backoffice user with the given role names
+func (s *BackofficeAdmin) AssignRoles(ctx context.Context, id uint, roles []string) error {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	uid, err := s.firebaseUid(ctx, user)
+	if err != nil {
+		return err
+	}
+	if err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM consumers.back_office_user_roles WHERE back_office_user_id = ?", id).Error; err != nil {
+			return errors.Wrapf(err, "can't delete roles of backoffice user %v", id)
+		}
+		return assignBackofficeRoles(tx, id, roles)
+	}); err != nil {
+		return err
+	}
+	Invalidate(uid)
+	return nil
+}
+
+// SetAdmin grants (or removes) the admin flag of the backoffice user
+func (s *BackofficeAdmin) SetAdmin(ctx context.Context, id uint, isAdmin bool) error {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	uid, err := s.firebaseUid(ctx, user)
+	if err != nil {
+		return err
+	}
+	if err = s.DB.WithContext(ctx).Table(backofficeUsersTable).Where("id = ?", id).Update("is_admin", isAdmin).Error; err != nil {
+		return errors.Wrapf(err, "can't update backoffice user %v", id)
+	}
+	Invalidate(uid)
+	return nil
+}
+
+// PasswordResetLink generates a password reset link of the backoffice user, also used to re-send invites
+func (s *BackofficeAdmin) PasswordResetLink(ctx context.Context, id uint) (string, error) {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	link, err := s.Client.PasswordResetLinkWithSettings(ctx, user.Email, s.LinkSettings)
+	return link, errors.Wrapf(err, "can't generate password reset link of backoffice user %v", id)
+}
+
+func (s *BackofficeAdmin) getUser(ctx context.Context, id uint) (BackOfficeUser, error) {
+	var user BackOfficeUser
+	if err := s.DB.WithContext(ctx).Table(backofficeUsersTable).First(&user, id).Error; err != nil {
+		return BackOfficeUser{}, errors.Wrapf(err, "can't find backoffice user %v", id)
+	}
+	return user, nil
+}
+
+// firebaseUid returns the firebase uid of the backoffice user, empty if it has no firebase user
+func (s *BackofficeAdmin) firebaseUid(ctx context.Context, user BackOfficeUser) (string, error) {
+	userRecord, err := s.Client.GetUserByEmail(ctx, user.Email)
+	if auth.IsUserNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "can't get firebase user of backoffice user %v", user.ID)
+	}
+	return userRecord.UID, nil
+}
+
+// deleteAssignments deletes the role and tenant assignments of the backoffice user, only from the enabled tables
+func (s *BackofficeAdmin) deleteAssignments(tx *gorm.DB, id uint) error {
+	if s.Roles {
+		if err := tx.Exec("DELETE FROM consumers.back_office_user_roles WHERE back_office_user_id = ?", id).Error; err != nil {
+			return errors.Wrapf(err, "can't delete roles of backoffice user %v", id)
+		}
+	}
+	if s.Tenancy {
+		if err := tx.Exec("DELETE FROM consumers.back_office_user_tenants WHERE back_office_user_id = ?", id).Error; err != nil {
+			return errors.Wrapf(err, "can't delete tenants of backoffice user %v", id)
+		}
+	}
+	return nil
+}
+
+func (s *BackofficeAdmin) deleteFirebaseUser(ctx context.Context, uid string) {
+	if err := s.Client.DeleteUser(ctx, uid); err != nil {
+		log.Errorf("Got error while deleting orphan firebase user %v: %v", uid, err)
+	}
+}
+
+func assignBackofficeRoles(tx *gorm.DB, backofficeUserId uint, roles []string) error {
+	roles = distinct(roles)
+	if len(roles) == 0 {
+		return nil
+	}
+	var roleIds []uint
+	if err := tx.Raw("SELECT id FROM consumers.back_office_roles WHERE name IN ?", roles).Scan(&roleIds).Error; err != nil {
+		return errors.Wrap(err, "can't get roles")
+	}
+	if len(roleIds) != len(roles) {
+		return errors.Wrapf(ErrUnknownBackofficeRole, "roles %v", roles)
+	}
+	for _, roleId := range roleIds {
+		if err := tx.Exec("INSERT INTO consumers.back_office_user_roles (back_office_user_id, role_id) VALUES (?, ?)", backofficeUserId, roleId).Error; err != nil {
+			return errors.Wrapf(err, "can't assign role %v to backoffice user %v", roleId, backofficeUserId)
+		}
+	}
+	return nil
+}
+
+func distinct(values []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
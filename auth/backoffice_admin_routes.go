@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/response"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+type backofficeUserLinkResponse struct {
+	ID   uint   `json:"id"`
+	Link string `json:"link"`
+}
+
+type assignRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+type setAdminRequest struct {
+	IsAdmin bool `json:"isAdmin"`
+}
+
+// RegisterRoutes mounts the backoffice user management api on the given group, for admins only (the group must be authenticated).
+// With Tenancy, only platform admins may use it (a tenant admin could otherwise create admins of every tenant, or manage users of other tenants).
+// Usage: admin.RegisterRoutes(router.Group("/backoffice-users", auth.Realm(auth.Backoffice), authenticator.AuthMiddleware, authenticator.RequireAuth))
+func (s *BackofficeAdmin) RegisterRoutes(group *gin.RouterGroup) {
+	group.Use(RequireAdminAuth, s.requirePlatformAdmin)
+	group.POST("", s.handleCreateUser)
+	group.POST("/:id/disable", s.handleSetDisabled(true))
+	group.POST("/:id/enable", s.handleSetDisabled(false))
+	group.DELETE("/:id", s.handleDeleteUser)
+	group.PUT("/:id/roles", s.handleAssignRoles)
+	group.PUT("/:id/admin", s.handleSetAdmin)
+	group.POST("/:id/password-reset-link", s.handlePasswordResetLink)
+}
+
+// requirePlatformAdmin : to limit the management of backoffice users to platform admins, when the backoffice users are assigned to tenants
+func (s *BackofficeAdmin) requirePlatformAdmin(ctx *gin.Context) {
+	if p, exists := PrincipalFrom(ctx); s.Tenancy && (!exists || !p.AllTenants) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. Only platform admins can manage backoffice users.")})
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+func (s *BackofficeAdmin) handleCreateUser(ctx *gin.Context) {
+	var req CreateBackofficeUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorResponse("Got error while binding backoffice user", errors.WithStack(err)))
+		return
+	}
+	user, link, err := s.CreateUser(ctx, req)
+	if err != nil {
+		ctx.JSON(backofficeAdminErrorStatus(err), response.NewErrorResponse("Got error while creating backoffice user", err))
+		return
+	}
+	ctx.JSON(http.StatusOK, backofficeUserLinkResponse{ID: user.ID, Link: link})
+}
+
+func (s *BackofficeAdmin) handleSetDisabled(disabled bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := backofficeUserIdParam(ctx)
+		if !ok {
+			return
+		}
+		returnBackofficeAdminResult(ctx, id, "Updated backoffice user", s.SetDisabled(ctx, id, disabled))
+	}
+}
+
+func (s *BackofficeAdmin) handleDeleteUser(ctx *gin.Context) {
+	id, ok := backofficeUserIdParam(ctx)
+	if !ok {
+		return
+	}
+	if id == GetAuthenticatedBackofficeUserId(ctx) {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorMessageResponse("Can't delete the authenticated backoffice user"))
+		return
+	}
+	returnBackofficeAdminResult(ctx, id, "Deleted backoffice user", s.DeleteUser(ctx, id))
+}
+
+func (s *BackofficeAdmin) handleAssignRoles(ctx *gin.Context) {
+	id, ok := backofficeUserIdParam(ctx)
+	if !ok {
+		return
+	}
+	var req assignRolesRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorResponse("Got error while binding roles", errors.WithStack(err)))
+		return
+	}
+	returnBackofficeAdminResult(ctx, id, "Assigned backoffice user roles", s.AssignRoles(ctx, id, req.Roles))
+}
+
+func (s *BackofficeAdmin) handleSetAdmin(ctx *gin.Context) {
+	id, ok := backofficeUserIdParam(ctx)
+	if !ok {
+		return
+	}
+	var req setAdminRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorResponse("Got error while binding admin flag", errors.WithStack(err)))
+		return
+	}
+	returnBackofficeAdminResult(ctx, id, "Updated backoffice user", s.SetAdmin(ctx, id, req.IsAdmin))
+}
+
+func (s *BackofficeAdmin) handlePasswordResetLink(ctx *gin.Context) {
+	id, ok := backofficeUserIdParam(ctx)
+	if !ok {
+		return
+	}
+	link, err := s.PasswordResetLink(ctx, id)
+	if err != nil {
+		returnBackofficeAdminResult(ctx, id, "", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, backofficeUserLinkResponse{ID: id, Link: link})
+}
+
+func backofficeUserIdParam(ctx *gin.Context) (uint, bool) {
+	paramVal := ctx.Params.ByName("id")
+	id, err := strconv.ParseUint(paramVal, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorResponseF(errors.WithStack(err), "can't bind param: id to uint (value = %v)", paramVal))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func returnBackofficeAdminResult(ctx *gin.Context, id uint, message string, err error) {
+	switch {
+	case err == nil:
+		ctx.JSON(http.StatusOK, response.Response{Message: message, ID: id})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, response.NewErrorResponse(fmt.Sprintf("Backoffice user %v not found", id), err))
+	default:
+		ctx.JSON(backofficeAdminErrorStatus(err), response.NewErrorResponse(fmt.Sprintf("Got error while managing backoffice user %v", id), err))
+	}
+}
+
+func backofficeAdminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownBackofficeRole):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrBackofficeUserExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
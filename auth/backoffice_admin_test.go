@@ -0,0 +1,30 @@
+package auth_test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/auth/authtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackofficeAdminRoutesRequirePlatformAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tenantAdmin := authtest.Admin(3)
+	tenantAdmin.TenantIDs, tenantAdmin.AllTenants = []uint{1}, false
+
+	createUser := func(admin *auth.BackofficeAdmin, p *auth.Principal) *httptest.ResponseRecorder {
+		router := gin.New()
+		admin.RegisterRoutes(router.Group("/backoffice-users", authtest.Middleware(p)))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/backoffice-users", strings.NewReader(`{}`)))
+		return recorder
+	}
+
+	authtest.AssertForbidden(t, createUser(&auth.BackofficeAdmin{Tenancy: true}, tenantAdmin), "platform admins")
+	// the invalid body is rejected by the handler, past the platform admin check
+	authtest.AssertStatus(t, createUser(&auth.BackofficeAdmin{Tenancy: true}, authtest.Admin(4)), http.StatusBadRequest)
+	authtest.AssertStatus(t, createUser(&auth.BackofficeAdmin{}, tenantAdmin), http.StatusBadRequest)
+}
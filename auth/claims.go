@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Custom claims written to the firebase users: RequireAuth looks up consumers and backoffice users by id (instead of email / uid).
+// The guest flag of consumers, and the admin flag and roles of backoffice users, are always read from the DB (cached),
+// so a guest upgrade or a demotion doesn't wait for a token refresh.
+const (
+	ConsumerIdClaim       = "consumer_id"
+	IsGuestClaim          = "is_guest"
+	BackofficeUserIdClaim = "backoffice_user_id"
+)
+
+// legacyBackofficeClaims are no longer written, and removed by SyncBackofficeUserClaims
+var legacyBackofficeClaims = []string{"is_admin", "backoffice_roles"}
+
+// ClaimsUpdater is the subset of the firebase auth client used to update custom claims (implemented by *auth.Client and *auth.TenantClient)
+type ClaimsUpdater interface {
+	GetUser(ctx context.Context, uid string) (*auth.UserRecord, error)
+	SetCustomUserClaims(ctx context.Context, uid string, customClaims map[string]interface{}) error
+}
+
+// SyncConsumerClaims writes the consumer identity into the custom claims of the given firebase user.
+// Should be called whenever a consumer is created, the guest flag of the claims is informative (RequireAuth reads it from the DB).
+func SyncConsumerClaims(ctx context.Context, client ClaimsUpdater, uid string, consumerId uint, isGuest bool) error {
+	return updateCustomClaims(ctx, client, uid, map[string]interface{}{
+		ConsumerIdClaim: consumerId,
+		IsGuestClaim:    isGuest,
+	})
+}
+
+// SyncBackofficeUserClaims writes the backoffice user id into the custom claims of the given firebase user
+func SyncBackofficeUserClaims(ctx context.Context, client ClaimsUpdater, uid string, backofficeUserId uint) error {
+	claims := map[string]interface{}{BackofficeUserIdClaim: backofficeUserId}
+	for _, claim := range legacyBackofficeClaims {
+		claims[claim] = nil
+	}
+	return updateCustomClaims(ctx, client, uid, claims)
+}
+
+// updateCustomClaims merges the given claims into the existing custom claims of the user (nil values remove the claim), and evicts its cached identity
+func updateCustomClaims(ctx context.Context, client ClaimsUpdater, uid string, claims map[string]interface{}) error {
+	userRecord, err := client.GetUser(ctx, uid)
+	if err != nil {
+		return errors.Wrapf(err, "can't get firebase user %v", uid)
+	}
+	customClaims := map[string]interface{}{}
+	for key, value := range userRecord.CustomClaims {
+		customClaims[key] = value
+	}
+	for key, value := range claims {
+		if value == nil {
+			delete(customClaims, key)
+		} else {
+			customClaims[key] = value
+		}
+	}
+	if err = client.SetCustomUserClaims(ctx, uid, customClaims); err != nil {
+		return errors.Wrapf(err, "can't set custom claims of firebase user %v", uid)
+	}
+	Invalidate(uid)
+	return nil
+}
+
+func getTokenClaims(ctx *gin.Context) map[string]interface{} {
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.Claims
+	}
+	return nil
+}
+
+func consumerFromClaims(claims map[string]interface{}) (GetConsumerResult, bool) {
+	consumerId, ok := uintClaim(claims, ConsumerIdClaim)
+	if !ok || consumerId == 0 {
+		return GetConsumerResult{}, false
+	}
+	isGuest, ok := claims[IsGuestClaim].(bool)
+	if !ok {
+		return GetConsumerResult{}, false
+	}
+	return GetConsumerResult{ID: consumerId, IsGuest: isGuest}, true
+}
+
+func backofficeUserFromClaims(claims map[string]interface{}) (GetBackofficeUserResult, bool) {
+	backofficeUserId, ok := uintClaim(claims, BackofficeUserIdClaim)
+	if !ok || backofficeUserId == 0 {
+		return GetBackofficeUserResult{}, false
+	}
+	return GetBackofficeUserResult{ID: backofficeUserId}, true
+}
+
+func uintClaim(claims map[string]interface{}, key string) (uint, bool) {
+	switch value := claims[key].(type) {
+	case float64:
+		return uint(value), value >= 0
+	case json.Number:
+		number, err := value.Int64()
+		return uint(number), err == nil && number >= 0
+	case uint:
+		return value, true
+	case int:
+		return uint(value), value >= 0
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,26 @@
+package auth_test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/auth/authtest"
+	"net/http"
+	"testing"
+)
+
+func TestConsumerClaimsDontOverrideUpgrade(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := authtest.NewMinter()
+	minter.Resolver.AddConsumer("upgraded-user", auth.GetConsumerResult{ID: 7, IsGuest: false})
+	staleToken := minter.Token(t, "upgraded-user", "", map[string]interface{}{auth.ConsumerIdClaim: 7, auth.IsGuestClaim: true})
+
+	authenticator := minter.Authenticator()
+	router := gin.New()
+	router.GET("/orders", authenticator.AuthMiddleware, authenticator.RequireAuth, func(ctx *gin.Context) {
+		if auth.GetAuthenticatedConsumerId(ctx) != 7 || auth.GetIsGuest(ctx) {
+			t.Errorf("expected the upgraded consumer 7, got consumer %v (guest: %v)", auth.GetAuthenticatedConsumerId(ctx), auth.GetIsGuest(ctx))
+		}
+		ctx.Status(http.StatusOK)
+	})
+	authtest.AssertStatus(t, serve(router, staleToken, auth.Consumer, "/orders"), http.StatusOK)
+}
@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/env"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// DebugConsumerIdHeader lets local development requests (ENV=local only) act as the given consumer id, without a token
+const DebugConsumerIdHeader = "X-Debug-Consumer-Id"
+
+// debugPrincipal returns the consumer principal asserted by the debug header, ignored unless ENV is exactly "local"
+func debugPrincipal(ctx *gin.Context) (*Principal, bool) {
+	headerValue := ctx.GetHeader(DebugConsumerIdHeader)
+	if headerValue == "" || env.GetEnvVar("ENV") != "local" {
+		return nil, false
+	}
+	consumerId, err := strconv.ParseUint(headerValue, 10, 64)
+	if err != nil || consumerId == 0 {
+		log.Warnf("Ignoring invalid %v header: %v", DebugConsumerIdHeader, headerValue)
+		return nil, false
+	}
+	log.Warnf("Local debug request acting as consumer %v", consumerId)
+	return &Principal{UID: "debug-" + headerValue, Realm: Consumer, ConsumerID: uint(consumerId)}, true
+}
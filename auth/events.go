@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	requestid "github.com/let-commerce/backend-common/request-id"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+type AuthEventType string
+
+const (
+	BackofficeLoginEvent       AuthEventType = "backoffice_login"       // first request of a backoffice sign-in session
+	AdminAccessDeniedEvent     AuthEventType = "admin_access_denied"    // RequireAdminAuth denied a non admin
+	UnauthenticatedAccessEvent AuthEventType = "unauthenticated_access" // a consumer acted on another consumer's resource (ValidateAuthorized)
+	IdentityCacheMissEvent     AuthEventType = "identity_cache_miss"    // the identity was resolved from the DB / firebase
+)
+
+const authEventsTable = "consumers.auth_events"
+
+// AuthEvent is a security relevant auth event, with the request and the principal it happened on (migrated by the consumers service)
+type AuthEvent struct {
+	ID               uint `gorm:"primarykey"`
+	CreatedAt        time.Time
+	Type             AuthEventType `gorm:"index;not null"`
+	Details          string
+	RequestID        string
+	IP               string
+	UserAgent        string
+	Method           string
+	Path             string
+	UID              string `gorm:"index"`
+	Realm            RealmName
+	ConsumerID       uint
+	BackofficeUserID uint `gorm:"index"`
+	ServiceName      string
+	ImpersonatorID   uint
+}
+
+// EventSink receives the emitted auth events, must not block the request
+type EventSink interface {
+	Emit(event AuthEvent)
+}
+
+// AuthEventSink receives all the auth events, no events are emitted when nil
+// Usage: auth.AuthEventSink = auth.MultiSink{auth.LogSink{}, auth.NewPostgresSink(db, 1000)}
+var AuthEventSink EventSink
+
+// TrustForwardedFor records the ips of auth events and impersonation audits by gin's ClientIP (X-Forwarded-For) instead of the connection remote address.
+// Only set it once the engine trusted proxies are configured (engine.SetTrustedProxies), like LockoutConfig.TrustForwardedFor.
+var TrustForwardedFor bool
+
+// EmitAuthEvent emits an event of the request (with its principal, if authenticated) to the AuthEventSink
+func EmitAuthEvent(ctx *gin.Context, eventType AuthEventType, details string) {
+	if AuthEventSink == nil {
+		return
+	}
+	event := AuthEvent{
+		CreatedAt: time.Now(),
+		Type:      eventType,
+		Details:   details,
+		RequestID: requestid.GetRequestIDFromContext(ctx),
+		IP:        requestIP(ctx, TrustForwardedFor),
+		UserAgent: ctx.Request.UserAgent(),
+		Method:    ctx.Request.Method,
+		Path:      ctx.Request.URL.Path,
+	}
+	if p, ok := PrincipalFrom(ctx); ok {
+		event.UID, event.Realm, event.ServiceName = p.UID, p.Realm, p.ServiceName
+		event.ConsumerID, event.BackofficeUserID, event.ImpersonatorID = p.ConsumerID, p.BackofficeUserID, p.ImpersonatorID
+	}
+	AuthEventSink.Emit(event)
+}
+
+// requestIP returns the connection remote address of the request, or gin's ClientIP when the forwarded headers are trusted
+func requestIP(ctx *gin.Context, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		return ctx.ClientIP()
+	}
+	if ip, _ := ctx.RemoteIP(); ip != nil {
+		return ip.String()
+	}
+	return ctx.Request.RemoteAddr
+}
+
+// MultiSink emits the events to all its sinks
+type MultiSink []EventSink
+
+func (s MultiSink) Emit(event AuthEvent) {
+	for _, sink := range s {
+		sink.Emit(event)
+	}
+}
+
+// LogSink writes the events to the log, as structured fields (the "data" of the JSON log)
+type LogSink struct{}
+
+func (s LogSink) Emit(event AuthEvent) {
+	log.WithFields(log.Fields{
+		"authEvent":        event.Type,
+		"details":          event.Details,
+		"requestId":        event.RequestID,
+		"ip":               event.IP,
+		"userAgent":        event.UserAgent,
+		"path":             event.Method + " " + event.Path,
+		"uid":              event.UID,
+		"realm":            event.Realm,
+		"consumerId":       event.ConsumerID,
+		"backofficeUserId": event.BackofficeUserID,
+		"serviceName":      event.ServiceName,
+		"impersonatorId":   event.ImpersonatorID,
+	}).Infof("Auth event: %v", event.Type)
+}
+
+// ChannelSink sends the events to a channel (e.g. to forward them to a queue), events are dropped when the channel is full
+type ChannelSink chan AuthEvent
+
+func (s ChannelSink) Emit(event AuthEvent) {
+	select {
+	case s <- event:
+	default:
+		log.Warnf("Dropped auth event %v, the channel is full", event.Type)
+	}
+}
+
+// PostgresSink writes the events to the auth events table, in the background
+type PostgresSink struct {
+	db     *gorm.DB
+	events ChannelSink
+}
+
+// NewPostgresSink creates a PostgresSink buffering up to the given number of events
+func NewPostgresSink(db *gorm.DB, buffer int) *PostgresSink {
+	sink := &PostgresSink{db: db, events: make(ChannelSink, buffer)}
+	go sink.write()
+	return sink
+}
+
+func (s *PostgresSink) Emit(event AuthEvent) {
+	s.events.Emit(event)
+}
+
+func (s *PostgresSink) write() {
+	for event := range s.events {
+		if err := s.db.Table(authEventsTable).Create(&event).Error; err != nil {
+			log.Errorf("Got error while writing auth event %v: %v", event.Type, err)
+		}
+	}
+}
+
+// emitBackofficeLogin emits a BackofficeLoginEvent on the first request of a backoffice sign-in session
+func emitBackofficeLogin(ctx *gin.Context, p *Principal) {
+	if AuthEventSink == nil {
+		return
+	}
+	session := fmt.Sprintf("%v:%v", p.UID, sessionTime(p.Claims))
+	if _, seen := BackofficeSessionCache.Get(session); seen {
+		return
+	}
+	BackofficeSessionCache.Set(session, true)
+	EmitAuthEvent(ctx, BackofficeLoginEvent, "")
+}
+
+// sessionTime returns the sign-in time of the token (the firebase "auth_time" claim, or "iat" for other tokens)
+func sessionTime(claims map[string]interface{}) int64 {
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		return int64(authTime)
+	}
+	iat, _ := claims["iat"].(float64)
+	return int64(iat)
+}
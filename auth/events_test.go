@@ -0,0 +1,33 @@
+package auth_test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthEventIPIgnoresForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	events := make(auth.ChannelSink, 2)
+	auth.AuthEventSink = events
+	defer func() { auth.AuthEventSink, auth.TrustForwardedFor = nil, false }()
+
+	emit := func() auth.AuthEvent {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/admin", nil)
+		ctx.Request.RemoteAddr = "10.0.0.1:4321"
+		ctx.Request.Header.Set("X-Forwarded-For", "1.2.3.4")
+		auth.EmitAuthEvent(ctx, auth.AdminAccessDeniedEvent, "")
+		return <-events
+	}
+
+	if event := emit(); event.IP != "10.0.0.1" {
+		t.Fatalf("expected the remote address, got %v", event.IP)
+	}
+	auth.TrustForwardedFor = true
+	if event := emit(); event.IP != "1.2.3.4" {
+		t.Fatalf("expected the forwarded address, got %v", event.IP)
+	}
+}
@@ -2,30 +2,52 @@ package auth
 
 import (
 	"context"
-	firebase "firebase.google.com/go"
 	"firebase.google.com/go/auth"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/let-commerce/backend-common/env"
-	"github.com/orcaman/concurrent-map"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/api/option"
 	"gorm.io/gorm"
 	"net/http"
-	"path/filepath"
 	"strings"
+	"time"
 )
 
 var (
 	FirebaseAuthClient          *auth.Client
 	BackofficeAuthClient        *auth.Client
-	UserIdToConsumerCache       cmap.ConcurrentMap //using concurrent map to prevent thread safe issues -  map[string]GetConsumerResult
-	UserIdToBackofficeUserCache cmap.ConcurrentMap //using concurrent map to prevent thread safe issues - map[string]GetBackofficeUserResult
+	UserIdToConsumerCache       *IdentityCache[GetConsumerResult]
+	UserIdToBackofficeUserCache *IdentityCache[GetBackofficeUserResult]
+	APIKeyCache                 *IdentityCache[APIKey] // api key prefix -> api key
+	UserStatusCache             *IdentityCache[UserStatus]
+	TenantHostCache             *IdentityCache[uint] // host -> tenant id
+	BackofficeSessionCache      *IdentityCache[bool] // seen backoffice sign-in sessions, for the login audit events
 )
 
+// Init initializes the identity caches with the default config (local only)
 func Init() {
-	UserIdToConsumerCache = cmap.New()       //using concurrent map to prevent thread safe issues
-	UserIdToBackofficeUserCache = cmap.New() //using concurrent map to prevent thread safe issues
+	InitCaches(DefaultIdentityCacheConfig)
+}
+
+// InitCaches initializes the identity caches with the given config (e.g. to add a shared redis tier)
+func InitCaches(config IdentityCacheConfig) {
+	UserIdToConsumerCache = NewIdentityCache("consumers", config, func(result GetConsumerResult) bool { return result.ID == 0 })
+	UserIdToBackofficeUserCache = NewIdentityCache("backoffice-users", config, func(result GetBackofficeUserResult) bool { return result.ID == 0 })
+	APIKeyCache = NewIdentityCache("api-keys", config, func(key APIKey) bool { return key.ID == 0 })
+	TenantHostCache = NewIdentityCache("tenant-hosts", config, func(tenantId uint) bool { return tenantId == 0 })
+	statusConfig := config
+	statusConfig.TTL, statusConfig.NegativeTTL = config.RevocationTTL, config.RevocationTTL
+	UserStatusCache = NewIdentityCache[UserStatus]("user-status", statusConfig, nil)
+	sessionConfig := config
+	sessionConfig.TTL = 24 * time.Hour
+	BackofficeSessionCache = NewIdentityCache[bool]("backoffice-sessions", sessionConfig, nil)
+}
+
+// Invalidate evicts the cached consumer, backoffice user and user status of the given uid (e.g. after a guest upgrade or an admin demotion)
+func Invalidate(uid string) {
+	UserIdToConsumerCache.Invalidate(uid)
+	UserIdToBackofficeUserCache.Invalidate(uid)
+	UserStatusCache.Invalidate(uid)
 }
 
 func SetupAllFirebase(accountKeyPath string, backofficeKeyPath string) (consumersFirebase *auth.Client, backofficeFirebase *auth.Client) {
@@ -34,184 +56,264 @@ func SetupAllFirebase(accountKeyPath string, backofficeKeyPath string) (consumer
 	return consumersFirebase, backofficeFirebase
 }
 
+// SetupFirebase creates a firebase auth client from the given key file, panics on failure (see NewFirebaseClient)
 func SetupFirebase(accountKeyPath string) *auth.Client {
-	serviceAccountKeyFilePath, err := filepath.Abs(accountKeyPath)
-	if err != nil {
-		log.Panicf("Unable to load serviceAccountKeys.json file: %v", err)
-	}
-
-	opt := option.WithCredentialsFile(serviceAccountKeyFilePath)
-	//Firebase admin SDK initialization
-	app, err := firebase.NewApp(context.Background(), nil, opt)
-	if err != nil {
-		log.Panicf("Firebase Admin SDK load error: %v", err)
-	}
-	//Firebase Auth
-	auth, err := app.Auth(context.Background())
+	client, err := NewFirebaseClient(context.Background(), FirebaseConfig{CredentialsFile: accountKeyPath})
 	if err != nil {
 		log.Panicf("Firebase Auth load error: %v", err)
 	}
+	return client
+}
+
+// Authenticator holds the token verifiers used by the auth middlewares, per realm
+type Authenticator struct {
+	Verifiers    map[RealmName]TokenVerifier // e.g. Consumer, Backoffice, Service, or one realm per identity platform tenant
+	HeaderPolicy RealmHeaderPolicy           // how the RequestContext header is treated, see RealmHeaderPolicy
+	Lockout      *Lockout                    // optional lockout of ips and uids after repeated verification failures
+
+	ConsumerResolver       ConsumerResolver       // defaults to an SQLResolver on the "DB" gin context key
+	BackofficeUserResolver BackofficeUserResolver // defaults to an SQLResolver on the "DB" gin context key
+	ImpersonationAuditDB   *gorm.DB               // the DB of the impersonation audits, defaults to the "DB" gin context key (impersonation fails without one)
+}
+
+// NewAuthenticator creates an Authenticator with the given consumer and backoffice token verifiers
+// Usage: router.Use(authenticator.AuthMiddleware, authenticator.RequireAuth)
+func NewAuthenticator(consumerVerifier TokenVerifier, backofficeVerifier TokenVerifier) *Authenticator {
+	return &Authenticator{Verifiers: map[RealmName]TokenVerifier{Consumer: consumerVerifier, Backoffice: backofficeVerifier}}
+}
 
-	return auth
+// WithVerifier registers the token verifier of the given realm
+func (a *Authenticator) WithVerifier(realm RealmName, verifier TokenVerifier) *Authenticator {
+	if a.Verifiers == nil {
+		a.Verifiers = map[RealmName]TokenVerifier{}
+	}
+	a.Verifiers[realm] = verifier
+	return a
 }
 
 // AuthMiddleware : to verify all authorized operations
+//
+// Deprecated: reads the firebase clients from the "firebaseAuth" and "backofficeFirebaseAuth" gin context keys, use NewAuthenticator instead
 func AuthMiddleware(ctx *gin.Context) {
+	legacyAuthenticator(ctx).AuthMiddleware(ctx)
+}
+
+// RequireAuth : to verify all authorized operations, there exist a consumer id
+//
+// Deprecated: reads the firebase clients from the "firebaseAuth" and "backofficeFirebaseAuth" gin context keys, use NewAuthenticator instead
+func RequireAuth(ctx *gin.Context) {
+	legacyAuthenticator(ctx).RequireAuth(ctx)
+}
+
+func legacyAuthenticator(ctx *gin.Context) *Authenticator {
 	firebaseAuth := ctx.MustGet("firebaseAuth").(*auth.Client)
 	backofficeFirebaseAuth := ctx.MustGet("backofficeFirebaseAuth").(*auth.Client)
-	authorizationToken := ctx.GetHeader("Authorization")
-	requestContext := ctx.GetHeader("RequestContext")
-	jwtToken := strings.TrimSpace(strings.Replace(authorizationToken, "Bearer", "", 1))
-
-	var uid string
-	var done bool
+	return NewAuthenticator(NewFirebaseVerifier(firebaseAuth), NewFirebaseVerifier(backofficeFirebaseAuth))
+}
 
-	if requestContext == "Backoffice" {
-		uid, done = getUid(ctx, jwtToken, backofficeFirebaseAuth)
-	} else {
-		uid, done = getUid(ctx, jwtToken, firebaseAuth)
+// AuthMiddleware : to verify all authorized operations
+func (a *Authenticator) AuthMiddleware(ctx *gin.Context) {
+	if a.authenticate(ctx) {
+		ctx.Next()
 	}
-	if uid == "" || done {
+}
+
+// OptionalAuth : for endpoints serving both anonymous and logged in callers, authenticates (as AuthMiddleware and RequireAuth) only when a token is sent.
+// Requests without a token continue anonymously (no principal, GetIsGuest is true), invalid tokens are still rejected.
+// Usage: catalog.Use(authenticator.OptionalAuth)
+func (a *Authenticator) OptionalAuth(ctx *gin.Context) {
+	if bearerToken(ctx) == "" {
+		if p, ok := debugPrincipal(ctx); ok {
+			SetPrincipal(ctx, p)
+		} else {
+			ctx.Set("IS_GUEST", true)
+		}
+		ctx.Next()
 		return
 	}
+	if a.authenticate(ctx) && a.resolve(ctx) {
+		ctx.Next()
+	}
+}
 
-	ctx.Set("FIREBASE_USER_UID", uid)
-	ctx.Next()
+// authenticate verifies the request token and sets the token principal, returns false if the request was aborted
+func (a *Authenticator) authenticate(ctx *gin.Context) bool {
+	if p, ok := debugPrincipal(ctx); ok {
+		SetPrincipal(ctx, p)
+		return true
+	}
+	realm, ok := a.requestRealm(ctx)
+	if !ok {
+		return false
+	}
+	jwtToken := bearerToken(ctx)
+	verifier := a.verifier(realm)
+	if verifier == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - %v tokens are not accepted (%v)", realm, env.GetEnvVar("SERVICE_NAME"))})
+		ctx.Abort()
+		return false
+	}
+
+	if jwtToken == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - No id token found for this request (%v)", env.GetEnvVar("SERVICE_NAME"))})
+		ctx.Abort()
+		return false
+	}
+	token, err := verifier.VerifyToken(ctx, jwtToken)
+	if err != nil {
+		if a.Lockout != nil && a.Lockout.rejectFailure(ctx, err) {
+			return false
+		}
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - Token not verified, err: %v (%v)", err, env.GetEnvVar("SERVICE_NAME"))})
+		ctx.Abort()
+		return false
+	}
+
+	p := &Principal{UID: token.UID, Email: token.Email, Realm: realm, Claims: token.Claims}
+	if realm == Service {
+		if p.ServiceName = serviceNameFromToken(token); p.ServiceName == "" {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - Token has no %v claim (%v)", ServiceClaim, env.GetEnvVar("SERVICE_NAME"))})
+			ctx.Abort()
+			return false
+		}
+	}
+	SetPrincipal(ctx, p)
+	return true
 }
 
 // RequireAuth : to verify all authorized operations, there exist a consumer id
-func RequireAuth(ctx *gin.Context) {
-	uidValue, exists := ctx.Get("FIREBASE_USER_UID")
+func (a *Authenticator) RequireAuth(ctx *gin.Context) {
+	if a.resolve(ctx) {
+		ctx.Next()
+	}
+}
+
+// resolve resolves the consumer / backoffice user of the token principal, returns false if the request was aborted (or not authenticated)
+func (a *Authenticator) resolve(ctx *gin.Context) bool {
+	tokenPrincipal, exists := PrincipalFrom(ctx)
 	if !exists {
-		return
+		return false
 	}
-	uid := uidValue.(string)
-	firebaseAuth := ctx.MustGet("firebaseAuth").(*auth.Client)
-	backofficeFirebaseAuth := ctx.MustGet("backofficeFirebaseAuth").(*auth.Client)
-	requestContext := ctx.GetHeader("RequestContext")
-
-	var consumerCached, backofficeCached, isAdmin, isGuest, success bool
-	isGuest = true
-	var consumerId, backofficeUserId uint
-	var email string
-
-	if requestContext != "Backoffice" {
-		if cacheConsumerValue, ok := UserIdToConsumerCache.Get(uid); ok {
-			cacheConsumer := cacheConsumerValue.(GetConsumerResult)
-			if cacheConsumer.ID != 0 {
-				consumerId = cacheConsumer.ID
-				isGuest = cacheConsumer.IsGuest
-			}
-			consumerCached = ok
-		}
-		if !consumerCached {
-			email, success = tryGetUserEmail(ctx, firebaseAuth, uid)
-			if !success {
-				return
-			}
-			consumerId, isGuest, success = tryExtractConsumerIdFromUid(ctx, email, uid)
-		}
+	// services have no consumer / backoffice user (guards decide what they may access), local debug consumers are already resolved
+	if tokenPrincipal.IsService() || tokenPrincipal.ConsumerID != 0 {
+		return true
+	}
+	uid := tokenPrincipal.UID
+	verifier := a.verifier(tokenPrincipal.Realm)
+	email := tokenPrincipal.Email
+	identity := UserIdentity{UID: uid, email: email, lookupEmail: func(c context.Context) (string, error) {
+		var err error
+		email, err = verifier.GetUserEmail(c, uid)
+		return email, err
+	}}
+
+	var consumer GetConsumerResult
+	var backofficeUser GetBackofficeUserResult
+	var err error
+	if tokenPrincipal.Realm != Backoffice {
+		consumer, err = a.getConsumer(ctx, identity)
 	} else {
-		if cacheBackofficeUserValue, ok := UserIdToBackofficeUserCache.Get(uid); ok {
-			cacheBackofficeUser := cacheBackofficeUserValue.(GetBackofficeUserResult)
-			if cacheBackofficeUser.ID != 0 {
-				backofficeUserId = cacheBackofficeUser.ID
-				isAdmin = cacheBackofficeUser.IsAdmin
-			}
-			backofficeCached = ok
-		}
-		if !backofficeCached {
-			email, success = tryGetUserEmail(ctx, backofficeFirebaseAuth, uid)
-			if !success {
-				return
-			}
-			backofficeUserId, isAdmin, success = tryExtractBackofficeUserIdFromUid(ctx, email, uid)
-		}
+		backofficeUser, err = a.getBackofficeUser(ctx, identity)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Authentication Error - User record not found: %v, (%v)", err, env.GetEnvVar("SERVICE_NAME"))})
+		ctx.Abort()
+		return false
 	}
 
-	if consumerId == 0 && backofficeUserId == 0 {
+	if consumer.ID == 0 && backofficeUser.ID == 0 {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error. User not found.")})
 		ctx.Abort()
-		return
+		return false
 	}
 
-	if consumerId != 0 {
-		ctx.Set("AUTHENTICATED_CONSUMER_ID", consumerId)
-		ctx.Set("IS_GUEST", isGuest)
+	p := *tokenPrincipal
+	p.Email = email
+	p.ConsumerID, p.IsGuest = consumer.ID, consumer.IsGuest
+	p.BackofficeUserID, p.IsAdmin = backofficeUser.ID, backofficeUser.IsAdmin
+	p.Roles, p.Permissions, p.TenantIDs, p.AllTenants = backofficeUser.Roles, backofficeUser.Permissions, backofficeUser.TenantIDs, backofficeUser.AllTenants
+	if ctx.GetHeader(ImpersonationHeader) != "" && !tryImpersonate(ctx, a.consumerResolver(ctx), a.impersonationAuditDB(ctx), &p) {
+		return false
 	}
-	if backofficeUserId != 0 {
-		ctx.Set("AUTHENTICATED_BACKOFFICE_USER_ID", backofficeUserId)
-		ctx.Set("IS_ADMIN", isAdmin)
+	SetPrincipal(ctx, &p)
+	if p.IsBackofficeUser() && !p.IsImpersonated() {
+		emitBackofficeLogin(ctx, &p)
 	}
-	ctx.Next()
+	return true
 }
 
-// RequireAdminAuth : to verify only admins access specific endpoint
-func RequireAdminAuth(ctx *gin.Context) {
-	isAdmin, exists := ctx.Get("IS_ADMIN")
-
-	if !exists || !isAdmin.(bool) {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error. No sufficient permissions.")})
-		ctx.Abort()
-		return
+// getConsumer resolves the consumer of the given identity from the cache, the token custom claims or the consumer resolver.
+// The claims only give the consumer id, its guest flag is read by id from the resolver (a token issued before a guest upgrade has a stale one).
+func (a *Authenticator) getConsumer(ctx *gin.Context, identity UserIdentity) (GetConsumerResult, error) {
+	if cacheConsumer, ok := UserIdToConsumerCache.Get(identity.UID); ok {
+		return cacheConsumer, nil
 	}
-	ctx.Next()
+	EmitAuthEvent(ctx, IdentityCacheMissEvent, UserIdToConsumerCache.name)
+	var consumer GetConsumerResult
+	var err error
+	if claimsConsumer, ok := consumerFromClaims(getTokenClaims(ctx)); ok {
+		consumer, err = a.consumerResolver(ctx).GetConsumer(ctx, claimsConsumer.ID)
+	} else {
+		consumer, err = a.consumerResolver(ctx).ResolveConsumer(ctx, identity)
+	}
+	if err != nil {
+		log.Errorf("Got error while resolving consumer of uid %v: %v", identity.UID, err)
+		return GetConsumerResult{}, err
+	}
+	UserIdToConsumerCache.Set(identity.UID, consumer) // a missing consumer is cached only for the (short) negative ttl
+	return consumer, nil
 }
 
-func getUid(ctx *gin.Context, jwtToken string, firebaseAuth *auth.Client) (string, bool) {
-	if jwtToken == "" {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - No id token found for this request (%v)", env.GetEnvVar("SERVICE_NAME"))})
-		ctx.Abort()
-		return "", true
+// getBackofficeUser resolves the backoffice user of the given identity from the cache, the token custom claims or the backoffice user resolver
+func (a *Authenticator) getBackofficeUser(ctx *gin.Context, identity UserIdentity) (GetBackofficeUserResult, error) {
+	if cacheBackofficeUser, ok := UserIdToBackofficeUserCache.Get(identity.UID); ok {
+		return cacheBackofficeUser, nil
+	}
+	EmitAuthEvent(ctx, IdentityCacheMissEvent, UserIdToBackofficeUserCache.name)
+	var backofficeUser GetBackofficeUserResult
+	var err error
+	if claimsBackofficeUser, ok := backofficeUserFromClaims(getTokenClaims(ctx)); ok {
+		backofficeUser, err = a.backofficeUserResolver(ctx).GetBackofficeUser(ctx, claimsBackofficeUser.ID)
+	} else {
+		backofficeUser, err = a.backofficeUserResolver(ctx).ResolveBackofficeUser(ctx, identity)
 	}
-	//verify token
-	token, err := firebaseAuth.VerifyIDToken(ctx, jwtToken)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - Token not verified, err: %v (%v)", err, env.GetEnvVar("SERVICE_NAME"))})
-		ctx.Abort()
-		return "", true
+		log.Errorf("Got error while resolving backoffice user of uid %v: %v", identity.UID, err)
+		return GetBackofficeUserResult{}, err
 	}
-	return token.UID, false
+	UserIdToBackofficeUserCache.Set(identity.UID, backofficeUser) // a missing backoffice user is cached only for the (short) negative ttl
+	return backofficeUser, nil
 }
 
-func tryExtractConsumerIdFromUid(ctx *gin.Context, email string, uid string) (id uint, isGuest bool, success bool) {
-	var result GetConsumerResult
-	err2 := ctx.MustGet("DB").(*gorm.DB).Raw("SELECT id, is_guest FROM consumers.consumers WHERE email = ?", email).Scan(&result).Error
-	UserIdToConsumerCache.Set(uid, result)
-	if err2 != nil || result.ID == 0 {
-		return 0, true, false
+func (a *Authenticator) consumerResolver(ctx *gin.Context) ConsumerResolver {
+	if a.ConsumerResolver != nil {
+		return a.ConsumerResolver
 	}
-	return result.ID, result.IsGuest, true
+	return NewSQLResolver(ctx.MustGet("DB").(*gorm.DB))
 }
 
-type GetBackofficeUserResult struct {
-	ID      uint
-	IsAdmin bool
+func (a *Authenticator) backofficeUserResolver(ctx *gin.Context) BackofficeUserResolver {
+	if a.BackofficeUserResolver != nil {
+		return a.BackofficeUserResolver
+	}
+	return NewSQLResolver(ctx.MustGet("DB").(*gorm.DB))
 }
 
-type GetConsumerResult struct {
-	ID      uint
-	IsGuest bool
+func (a *Authenticator) verifier(realm RealmName) TokenVerifier {
+	return a.Verifiers[realm]
 }
 
-func tryExtractBackofficeUserIdFromUid(ctx *gin.Context, email string, uid string) (uint, bool, bool) {
-	var result GetBackofficeUserResult
-	err2 := ctx.MustGet("DB").(*gorm.DB).Raw("SELECT id, is_admin FROM consumers.back_office_users WHERE email = ?", email).Scan(&result).Error
-	UserIdToBackofficeUserCache.Set(uid, result)
-
-	if err2 != nil || result.ID == 0 {
-		return 0, false, false
+// RequireAdminAuth : to verify only admins access specific endpoint (services are denied, see RequireAdminOrService)
+func RequireAdminAuth(ctx *gin.Context) {
+	if !GetIsAdmin(ctx) {
+		EmitAuthEvent(ctx, AdminAccessDeniedEvent, "")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error. No sufficient permissions.")})
+		ctx.Abort()
+		return
 	}
-	return result.ID, result.IsAdmin, true
+	ctx.Next()
 }
 
-func tryGetUserEmail(ctx *gin.Context, firebaseAuth *auth.Client, uid string) (string, bool) {
-	userRecord, err := firebaseAuth.GetUser(ctx, uid)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Authentication Error - User record not found: %v, (%v)", err, env.GetEnvVar("SERVICE_NAME"))})
-		ctx.Abort()
-		return "", false
-	}
-	ctx.Set("FIREBASE_USER_EMAIL", userRecord.Email)
-	return userRecord.Email, true
+func bearerToken(ctx *gin.Context) string {
+	return strings.TrimSpace(strings.Replace(ctx.GetHeader("Authorization"), "Bearer", "", 1))
 }
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"container/list"
+	"encoding/json"
+	redigo "github.com/gomodule/redigo/redis"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const identityCacheKeyPrefix = "auth:identity-cache:"
+
+// IdentityCacheConfig configures the expiration, size and shared tier of an IdentityCache
+type IdentityCacheConfig struct {
+	TTL           time.Duration // how long a found identity is cached
+	NegativeTTL   time.Duration // how long a "not found" result is cached, keep short so new sign ups are picked up quickly
+	MaxSize       int           // max number of locally cached entries, least recently used entries are evicted first
+	RevocationTTL time.Duration // how long token revocation / disabled user checks are cached
+	Redis         *redigo.Pool  // optional shared tier, so values and invalidations are shared between all replicas
+}
+
+// DefaultIdentityCacheConfig is the config used by Init
+var DefaultIdentityCacheConfig = IdentityCacheConfig{
+	TTL:           10 * time.Minute,
+	NegativeTTL:   10 * time.Second,
+	MaxSize:       10000,
+	RevocationTTL: 30 * time.Second,
+}
+
+// IdentityCache is a bounded, expiring uid -> identity cache, with an optional redis backed shared tier
+type IdentityCache[V any] struct {
+	name       string
+	config     IdentityCacheConfig
+	isNegative func(V) bool
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type identityCacheEntry[V any] struct {
+	uid       string
+	value     V
+	expiresAt time.Time
+}
+
+// NewIdentityCache creates a new cache, isNegative reports whether a value is a "not found" result (cached for NegativeTTL)
+func NewIdentityCache[V any](name string, config IdentityCacheConfig, isNegative func(V) bool) *IdentityCache[V] {
+	cache := &IdentityCache[V]{
+		name:       name,
+		config:     config,
+		isNegative: isNegative,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+	if config.Redis != nil {
+		go cache.listenForInvalidations()
+	}
+	return cache
+}
+
+// Get returns the cached identity of the given uid, looking in the shared tier on a local miss
+func (c *IdentityCache[V]) Get(uid string) (V, bool) {
+	if value, ok := c.getLocal(uid); ok {
+		return value, true
+	}
+	var null V
+	if c.config.Redis == nil {
+		return null, false
+	}
+
+	value, ttl, ok := c.getShared(uid)
+	if !ok {
+		return null, false
+	}
+	c.setLocal(uid, value, ttl)
+	return value, true
+}
+
+// Set caches the identity of the given uid (locally and in the shared tier)
+func (c *IdentityCache[V]) Set(uid string, value V) {
+	ttl := c.ttl(value)
+	c.setLocal(uid, value, ttl)
+	if c.config.Redis != nil {
+		c.setShared(uid, value, ttl)
+	}
+}
+
+// Invalidate removes the given uid from the cache, on all replicas when a shared tier is configured
+func (c *IdentityCache[V]) Invalidate(uid string) {
+	if c == nil { // not initialized (e.g. admin tools that don't call Init)
+		return
+	}
+	c.removeLocal(uid)
+	if c.config.Redis == nil {
+		return
+	}
+
+	conn := c.config.Redis.Get()
+	defer conn.Close()
+	if _, err := conn.Do("DEL", c.key(uid)); err != nil {
+		log.Errorf("Got error while deleting identity cache key of uid %v: %v", uid, err)
+	}
+	if _, err := conn.Do("PUBLISH", c.channel(), uid); err != nil {
+		log.Errorf("Got error while publishing identity cache invalidation of uid %v: %v", uid, err)
+	}
+}
+
+// Len returns the number of locally cached entries (including expired ones not yet evicted)
+func (c *IdentityCache[V]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+func (c *IdentityCache[V]) ttl(value V) time.Duration {
+	if c.isNegative != nil && c.isNegative(value) {
+		return c.config.NegativeTTL
+	}
+	return c.config.TTL
+}
+
+func (c *IdentityCache[V]) getLocal(uid string) (V, bool) {
+	var null V
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[uid]
+	if !ok {
+		return null, false
+	}
+	entry := element.Value.(*identityCacheEntry[V])
+	if time.Now().After(entry.expiresAt) {
+		c.lru.Remove(element)
+		delete(c.entries, uid)
+		return null, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *IdentityCache[V]) setLocal(uid string, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		c.removeLocal(uid)
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &identityCacheEntry[V]{uid: uid, value: value, expiresAt: time.Now().Add(ttl)}
+	if element, ok := c.entries[uid]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[uid] = c.lru.PushFront(entry)
+	for c.config.MaxSize > 0 && c.lru.Len() > c.config.MaxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*identityCacheEntry[V]).uid)
+	}
+}
+
+func (c *IdentityCache[V]) removeLocal(uid string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[uid]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, uid)
+	}
+}
+
+func (c *IdentityCache[V]) getShared(uid string) (V, time.Duration, bool) {
+	var value V
+	conn := c.config.Redis.Get()
+	defer conn.Close()
+
+	reply, err := redigo.Values(conn.Do("EVAL", "return {redis.call('GET', KEYS[1]), redis.call('PTTL', KEYS[1])}", 1, c.key(uid)))
+	if err != nil || len(reply) != 2 || reply[0] == nil {
+		if err != nil {
+			log.Errorf("Got error while getting identity cache value of uid %v: %v", uid, err)
+		}
+		return value, 0, false
+	}
+	data, err := redigo.Bytes(reply[0], nil)
+	if err == nil {
+		err = json.Unmarshal(data, &value)
+	}
+	if err != nil {
+		log.Errorf("Got error while decoding identity cache value of uid %v: %v", uid, err)
+		return value, 0, false
+	}
+	ttl, _ := redigo.Int64(reply[1], nil)
+	return value, time.Duration(ttl) * time.Millisecond, true
+}
+
+func (c *IdentityCache[V]) setShared(uid string, value V, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("Got error while encoding identity cache value of uid %v: %v", uid, err)
+		return
+	}
+	conn := c.config.Redis.Get()
+	defer conn.Close()
+	if _, err = conn.Do("SET", c.key(uid), data, "PX", ttl.Milliseconds()); err != nil {
+		log.Errorf("Got error while setting identity cache value of uid %v: %v", uid, err)
+	}
+}
+
+// listenForInvalidations evicts local entries invalidated by other replicas, reconnecting on errors
+func (c *IdentityCache[V]) listenForInvalidations() {
+	for {
+		conn := redigo.PubSubConn{Conn: c.config.Redis.Get()}
+		if err := conn.Subscribe(c.channel()); err != nil {
+			log.Errorf("Can't subscribe to identity cache invalidations of %v: %v", c.name, err)
+		} else {
+			c.receiveInvalidations(conn)
+		}
+		conn.Close()
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (c *IdentityCache[V]) receiveInvalidations(conn redigo.PubSubConn) {
+	for {
+		switch message := conn.Receive().(type) {
+		case redigo.Message:
+			c.removeLocal(string(message.Data))
+		case error:
+			log.Errorf("Got error while receiving identity cache invalidations of %v: %v", c.name, message)
+			return
+		}
+	}
+}
+
+func (c *IdentityCache[V]) key(uid string) string {
+	return identityCacheKeyPrefix + c.name + ":" + uid
+}
+
+func (c *IdentityCache[V]) channel() string {
+	return identityCacheKeyPrefix + c.name + ":invalidate"
+}
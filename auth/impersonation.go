@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	requestid "github.com/let-commerce/backend-common/request-id"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ImpersonationHeader makes a request of an admin backoffice user run as the given consumer id
+const ImpersonationHeader = "X-Impersonate-Consumer-Id"
+
+const impersonationAuditsTable = "consumers.impersonation_audits"
+
+// ImpersonationAudit records a single request made by a backoffice user on behalf of a consumer, migrated by the consumers service
+type ImpersonationAudit struct {
+	ID               uint `gorm:"primarykey"`
+	CreatedAt        time.Time
+	BackofficeUserID uint `gorm:"index;not null"`
+	ConsumerID       uint `gorm:"index;not null"`
+	Method           string
+	Path             string
+	RequestID        string
+	RemoteIP         string
+	UserAgent        string
+}
+
+// tryImpersonate switches the principal to the consumer given in the impersonation header, the real backoffice user id is kept only as the impersonator.
+// The request then runs exactly as the consumer would (no backoffice user, no admin rights), limited to the tenants of the admin,
+// and is written to the impersonation audit table.
+func tryImpersonate(ctx *gin.Context, resolver ConsumerResolver, auditDB *gorm.DB, p *Principal) bool {
+	headerValue := ctx.GetHeader(ImpersonationHeader)
+	if !p.IsBackofficeUser() || !p.IsAdmin {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. Only admins can impersonate consumers.")})
+		ctx.Abort()
+		return false
+	}
+	if auditDB == nil {
+		log.Errorf("Got impersonation request of backoffice user %v without an impersonation audit DB", p.BackofficeUserID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Authentication Error. No impersonation audit DB is configured.")})
+		ctx.Abort()
+		return false
+	}
+	consumerId, err := strconv.ParseUint(headerValue, 10, 64)
+	if err != nil || consumerId == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Authentication Error. Invalid %v header: %v.", ImpersonationHeader, headerValue)})
+		ctx.Abort()
+		return false
+	}
+
+	consumer, err := resolver.GetConsumer(ctx, uint(consumerId))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Authentication Error. Can't get impersonated consumer: %v.", err)})
+		ctx.Abort()
+		return false
+	}
+	if consumer.ID == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Authentication Error. Impersonated consumer %v not found.", consumerId)})
+		ctx.Abort()
+		return false
+	}
+
+	audit := ImpersonationAudit{
+		BackofficeUserID: p.BackofficeUserID,
+		ConsumerID:       consumer.ID,
+		Method:           ctx.Request.Method,
+		Path:             ctx.Request.URL.Path,
+		RequestID:        requestid.GetRequestIDFromContext(ctx),
+		RemoteIP:         requestIP(ctx, TrustForwardedFor),
+		UserAgent:        ctx.Request.UserAgent(),
+	}
+	if err = auditDB.WithContext(ctx).Table(impersonationAuditsTable).Create(&audit).Error; err != nil { // no audit, no impersonation
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Authentication Error. Can't audit impersonation: %v.", err)})
+		ctx.Abort()
+		return false
+	}
+	log.Warnf("Backoffice user %v is impersonating consumer %v", p.BackofficeUserID, consumer.ID)
+
+	p.ImpersonatorID, p.BackofficeUserID = p.BackofficeUserID, 0
+	p.ConsumerID, p.IsGuest = consumer.ID, consumer.IsGuest
+	p.IsAdmin, p.Roles, p.Permissions = false, nil, nil // the admin tenants (and AllTenants) are kept, see CanAccessTenant
+	return true
+}
+
+// impersonationAuditDB returns the DB of the impersonation audits, nil if there is none
+func (a *Authenticator) impersonationAuditDB(ctx *gin.Context) *gorm.DB {
+	if a.ImpersonationAuditDB != nil {
+		return a.ImpersonationAuditDB
+	}
+	if value, exists := ctx.Get("DB"); exists {
+		db, _ := value.(*gorm.DB)
+		return db
+	}
+	return nil
+}
+
+func IsImpersonated(ctx *gin.Context) bool {
+	p, ok := PrincipalFrom(ctx)
+	return ok && p.IsImpersonated()
+}
+
+// GetImpersonatorId returns the real backoffice user id of an impersonated request
+func GetImpersonatorId(ctx *gin.Context) uint {
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.ImpersonatorID
+	}
+	return 0
+}
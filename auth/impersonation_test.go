@@ -0,0 +1,44 @@
+package auth_test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/auth/authtest"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImpersonationRequiresAuditDB(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := authtest.NewMinter()
+	minter.ConsumerToken(t, 7, false)
+	token := minter.BackofficeToken(t, 3, true)
+	authenticator := minter.Authenticator()
+
+	impersonate := func() *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/orders", auth.Realm(auth.Backoffice), authenticator.AuthMiddleware, authenticator.RequireAuth, func(ctx *gin.Context) {
+			if auth.GetImpersonatorId(ctx) != 3 || auth.GetAuthenticatedConsumerId(ctx) != 7 {
+				t.Errorf("expected backoffice user 3 to impersonate consumer 7")
+			}
+			ctx.Status(http.StatusOK)
+		})
+		req := authtest.Authorize(httptest.NewRequest(http.MethodGet, "/orders", nil), token, auth.Backoffice)
+		req.Header.Set(auth.ImpersonationHeader, "7")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	authtest.AssertStatus(t, impersonate(), http.StatusInternalServerError)
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatalf("can't open dry run db: %v", err)
+	}
+	authenticator.ImpersonationAuditDB = db
+	authtest.AssertStatus(t, impersonate(), http.StatusOK)
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const jwksRefreshInterval = time.Hour
+
+// JWTVerifier verifies locally signed JWTs, either with an HMAC shared secret or with public keys (static or from a JWKS endpoint)
+type JWTVerifier struct {
+	Issuer     string                 // expected "iss" claim, skipped when empty
+	Audience   string                 // expected "aud" claim, skipped when empty
+	HMACSecret []byte                 // used for HS256/HS384/HS512 tokens
+	Keys       map[string]interface{} // static public keys by "kid" (*rsa.PublicKey / *ecdsa.PublicKey)
+	JWKSURL    string                 // JWKS endpoint to fetch public keys from
+
+	mutex       sync.RWMutex
+	jwksKeys    map[string]interface{}
+	jwksFetched time.Time
+}
+
+// NewHMACVerifier creates a JWTVerifier for tokens signed with the given shared secret
+func NewHMACVerifier(secret []byte, issuer string, audience string) *JWTVerifier {
+	return &JWTVerifier{HMACSecret: secret, Issuer: issuer, Audience: audience}
+}
+
+// NewJWKSVerifier creates a JWTVerifier for tokens signed with the keys published at the given JWKS url
+func NewJWKSVerifier(jwksURL string, issuer string, audience string) *JWTVerifier {
+	return &JWTVerifier{JWKSURL: jwksURL, Issuer: issuer, Audience: audience}
+}
+
+func (v *JWTVerifier) VerifyToken(ctx context.Context, token string) (*VerifiedToken, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return v.key(ctx, t)
+	}, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	if errors.Is(err, jwt.ErrTokenExpired) { // the claims are validated only once the signature is verified
+		return nil, &RejectedTokenError{UID: tokenUid(claims), Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("token has no valid expiration")
+	}
+	if v.Issuer != "" && !claims.VerifyIssuer(v.Issuer, true) {
+		return nil, errors.Errorf("unexpected token issuer: %v", claims["iss"])
+	}
+	if v.Audience != "" && !claims.VerifyAudience(v.Audience, true) {
+		return nil, errors.Errorf("unexpected token audience: %v", claims["aud"])
+	}
+
+	uid := tokenUid(claims)
+	if uid == "" {
+		return nil, errors.New("token has no subject")
+	}
+	email, _ := claims["email"].(string)
+	var issuedAt int64
+	if iat, ok := claims["iat"].(float64); ok {
+		issuedAt = int64(iat)
+	}
+	return &VerifiedToken{UID: uid, Email: email, IssuedAt: issuedAt, Claims: claims}, nil
+}
+
+// tokenUid returns the "uid" claim, or the "sub" claim of tokens without one
+func tokenUid(claims jwt.MapClaims) string {
+	uid, _ := claims["uid"].(string)
+	if uid == "" {
+		uid, _ = claims["sub"].(string)
+	}
+	return uid
+}
+
+// GetUserEmail is not supported for local JWTs, the email must be part of the token claims (users without one are resolved by uid only)
+func (v *JWTVerifier) GetUserEmail(ctx context.Context, uid string) (string, error) {
+	return "", nil
+}
+
+func (v *JWTVerifier) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(v.HMACSecret) == 0 {
+			return nil, errors.New("hmac signed tokens are not accepted")
+		}
+		return v.HMACSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := v.Keys[kid]; ok {
+		return key, nil
+	}
+	if v.JWKSURL == "" {
+		return nil, errors.Errorf("unknown signing key: %v", kid)
+	}
+	return v.jwksKey(ctx, kid)
+}
+
+func (v *JWTVerifier) jwksKey(ctx context.Context, kid string) (interface{}, error) {
+	v.mutex.RLock()
+	key, ok := v.jwksKeys[kid]
+	fetched := v.jwksFetched
+	v.mutex.RUnlock()
+	if ok && time.Since(fetched) < jwksRefreshInterval {
+		return key, nil
+	}
+	// refresh on expiry, or on unknown kid (key rotation) - but not more than once a minute
+	if !ok && time.Since(fetched) < time.Minute {
+		return nil, errors.Errorf("unknown signing key: %v", kid)
+	}
+
+	keys, err := fetchJWKS(ctx, v.JWKSURL)
+	if err != nil {
+		if ok { // keep using the cached key if the endpoint is temporarily unavailable
+			return key, nil
+		}
+		return nil, err
+	}
+	v.mutex.Lock()
+	v.jwksKeys = keys
+	v.jwksFetched = time.Now()
+	v.mutex.Unlock()
+
+	if key, ok = keys[kid]; !ok {
+		return nil, errors.Errorf("unknown signing key: %v", kid)
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func fetchJWKS(ctx context.Context, url string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't fetch jwks from %v", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("can't fetch jwks from %v, status code: %v", url, resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, errors.Wrapf(err, "can't decode jwks from %v", url)
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil { // skip keys we can't use (e.g. encryption keys), instead of failing the whole set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported jwk curve: %v", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, errors.Errorf("unsupported jwk key type: %v", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't decode jwk value: %v", value)
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
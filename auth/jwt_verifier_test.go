@@ -0,0 +1,105 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/let-commerce/backend-common/auth"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("jwt-verifier-test-secret")
+
+func signHS256(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("can't sign token: %v", err)
+	}
+	return token
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "user-1", "email": "user@example.com", "iss": "issuer", "aud": "audience", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestJWTVerifierAcceptsValidToken(t *testing.T) {
+	verifier := auth.NewHMACVerifier(testSecret, "issuer", "audience")
+	token, err := verifier.VerifyToken(context.Background(), signHS256(t, testSecret, validClaims()))
+	if err != nil {
+		t.Fatalf("expected a valid token, got: %v", err)
+	}
+	if token.UID != "user-1" || token.Email != "user@example.com" {
+		t.Fatalf("unexpected token identity: %+v", token)
+	}
+}
+
+func TestJWTVerifierRejectsAlgorithms(t *testing.T) {
+	verifier := auth.NewHMACVerifier(testSecret, "issuer", "audience")
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("can't create unsigned token: %v", err)
+	}
+	if _, err = verifier.VerifyToken(context.Background(), unsigned); err == nil {
+		t.Fatal("expected an unsigned (alg none) token to be rejected")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("can't generate rsa key: %v", err)
+	}
+	rsaSigned, err := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims()).SignedString(key)
+	if err != nil {
+		t.Fatalf("can't sign rsa token: %v", err)
+	}
+	if _, err = verifier.VerifyToken(context.Background(), rsaSigned); err == nil {
+		t.Fatal("expected an RS256 token to be rejected by an hmac only verifier")
+	}
+
+	if _, err = verifier.VerifyToken(context.Background(), signHS256(t, []byte("another-secret"), validClaims())); err == nil {
+		t.Fatal("expected a token signed with another secret to be rejected")
+	}
+}
+
+func TestJWTVerifierRejectsExpiry(t *testing.T) {
+	verifier := auth.NewHMACVerifier(testSecret, "issuer", "audience")
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	_, err := verifier.VerifyToken(context.Background(), signHS256(t, testSecret, expired))
+	var rejectedErr *auth.RejectedTokenError
+	if !errors.As(err, &rejectedErr) || rejectedErr.UID != "user-1" {
+		t.Fatalf("expected a rejected token error of user-1, got: %v", err)
+	}
+
+	_, err = verifier.VerifyToken(context.Background(), signHS256(t, []byte("another-secret"), expired))
+	if err == nil || errors.As(err, &rejectedErr) {
+		t.Fatalf("expected a forged expired token to fail as unverified, got: %v", err)
+	}
+
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+	if _, err = verifier.VerifyToken(context.Background(), signHS256(t, testSecret, noExpiry)); err == nil {
+		t.Fatal("expected a token without expiration to be rejected")
+	}
+}
+
+func TestJWTVerifierRejectsIssuerAndAudience(t *testing.T) {
+	verifier := auth.NewHMACVerifier(testSecret, "issuer", "audience")
+
+	otherIssuer := validClaims()
+	otherIssuer["iss"] = "another-issuer"
+	if _, err := verifier.VerifyToken(context.Background(), signHS256(t, testSecret, otherIssuer)); err == nil {
+		t.Fatal("expected a token of another issuer to be rejected")
+	}
+
+	otherAudience := validClaims()
+	otherAudience["aud"] = "another-audience"
+	if _, err := verifier.VerifyToken(context.Background(), signHS256(t, testSecret, otherAudience)); err == nil {
+		t.Fatal("expected a token of another audience to be rejected")
+	}
+}
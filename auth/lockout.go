@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/let-commerce/backend-common/logs"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	lockoutKeyPrefix     = "auth:lockout:"
+	lockoutHistoryTTL    = 24 * time.Hour
+	lockoutMaxUIDLength  = 128
+	LockoutSecurityEvent = "auth_lockout"
+)
+
+// recordFailureScript counts a failure, and locks the key out (for a doubled duration on every lockout of the last day) once the threshold is reached.
+// KEYS: failures, lock, lockouts. ARGV: window ms, threshold, base lockout ms, max lockout ms, history ms. Returns the lockout ms, 0 if not locked.
+var recordFailureScript = redigo.NewScript(3, `
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+if failures < tonumber(ARGV[2]) then return 0 end
+redis.call('DEL', KEYS[1])
+local lockouts = redis.call('INCR', KEYS[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[5])
+local duration = math.floor(math.min(tonumber(ARGV[3]) * 2 ^ (lockouts - 1), tonumber(ARGV[4])))
+redis.call('SET', KEYS[2], lockouts, 'PX', duration)
+return duration`)
+
+// LockoutConfig configures the lockout of ips and uids after repeated token verification failures
+type LockoutConfig struct {
+	Redis       *redigo.Pool
+	Threshold   int           // failures within the Window before a lockout (defaults to 10)
+	Window      time.Duration // failures counting window (defaults to 10m)
+	BaseLockout time.Duration // first lockout duration, doubled on every further lockout in a day (defaults to 1m)
+	MaxLockout  time.Duration // longest lockout (defaults to 1h)
+	// TrustForwardedFor keys the ips by gin's ClientIP (X-Forwarded-For) instead of the connection remote address.
+	// Only set it once the engine trusted proxies are configured (engine.SetTrustedProxies), gin 1.7 trusts the header of any caller by default.
+	TrustForwardedFor bool
+}
+
+// DefaultLockoutConfig is used for the zero fields of a LockoutConfig
+var DefaultLockoutConfig = LockoutConfig{Threshold: 10, Window: 10 * time.Minute, BaseLockout: time.Minute, MaxLockout: time.Hour}
+
+// Lockout counts failed token verifications per ip, and per uid for tokens whose signature verified but were rejected (see RejectedTokenError).
+// Only failing tokens of locked out callers are answered with 429, a token that verifies is never locked out (so forged tokens can't lock a user out).
+// The lock is checked only after a verification failed, so it doesn't throttle nor slow down credential stuffing (every token is still verified):
+// it turns the failures of locked out callers into 429s and emits security events, rate limiting must be done at the edge (e.g. the load balancer).
+// Redis errors fail open, so a redis outage never blocks authentication.
+type Lockout struct {
+	config LockoutConfig
+}
+
+// NewLockout creates a Lockout with the given config, set it on Authenticator.Lockout
+func NewLockout(config LockoutConfig) *Lockout {
+	if config.Threshold == 0 {
+		config.Threshold = DefaultLockoutConfig.Threshold
+	}
+	if config.Window == 0 {
+		config.Window = DefaultLockoutConfig.Window
+	}
+	if config.BaseLockout == 0 {
+		config.BaseLockout = DefaultLockoutConfig.BaseLockout
+	}
+	if config.MaxLockout == 0 {
+		config.MaxLockout = DefaultLockoutConfig.MaxLockout
+	}
+	return &Lockout{config: config}
+}
+
+// rejectFailure handles a failed token verification: writes a 429 with Retry-After and aborts when the ip or the token uid is locked out,
+// otherwise counts the failure and returns false (the caller answers it)
+func (l *Lockout) rejectFailure(ctx *gin.Context, verifyErr error) bool {
+	keys := l.keys(ctx, verifyErr)
+	if l.checkLocked(ctx, keys) {
+		return true
+	}
+	l.recordFailure(ctx, keys)
+	return false
+}
+
+// checkLocked writes a 429 with Retry-After and aborts when one of the keys is locked out
+func (l *Lockout) checkLocked(ctx *gin.Context, keys []string) bool {
+	conn := l.config.Redis.Get()
+	defer conn.Close()
+	for _, key := range keys {
+		ttl, err := redigo.Int64(conn.Do("PTTL", lockoutKeyPrefix+"lock:"+key))
+		if err != nil {
+			log.Errorf("Got error while checking auth lockout of %v: %v", key, err)
+			return false
+		}
+		if ttl > 0 {
+			retryAfter := int64(math.Ceil(float64(ttl) / 1000))
+			ctx.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("Authentication Error - Too many failed attempts, retry in %v seconds", retryAfter)})
+			ctx.Abort()
+			return true
+		}
+	}
+	return false
+}
+
+// recordFailure counts a failed verification of the keys, and emits a security event on each lockout
+func (l *Lockout) recordFailure(ctx *gin.Context, keys []string) {
+	conn := l.config.Redis.Get()
+	defer conn.Close()
+	for _, key := range keys {
+		duration, err := redigo.Int64(recordFailureScript.Do(conn,
+			lockoutKeyPrefix+"failures:"+key, lockoutKeyPrefix+"lock:"+key, lockoutKeyPrefix+"lockouts:"+key,
+			l.config.Window.Milliseconds(), l.config.Threshold, l.config.BaseLockout.Milliseconds(), l.config.MaxLockout.Milliseconds(), lockoutHistoryTTL.Milliseconds()))
+		if err != nil {
+			log.Errorf("Got error while recording auth failure of %v: %v", key, err)
+			return
+		}
+		if duration > 0 {
+			logs.SecurityEvent(LockoutSecurityEvent, log.Fields{
+				"key":       key,
+				"ip":        l.clientIP(ctx),
+				"path":      ctx.Request.URL.Path,
+				"userAgent": ctx.Request.UserAgent(),
+				"lockedFor": (time.Duration(duration) * time.Millisecond).String(),
+				"threshold": l.config.Threshold,
+			})
+		}
+	}
+}
+
+// keys returns the lockout keys of a failed verification, the client ip and the uid of a verified but rejected token
+func (l *Lockout) keys(ctx *gin.Context, verifyErr error) []string {
+	keys := []string{"ip:" + l.clientIP(ctx)}
+	var rejectedErr *RejectedTokenError
+	if errors.As(verifyErr, &rejectedErr) && rejectedErr.UID != "" && len(rejectedErr.UID) <= lockoutMaxUIDLength {
+		keys = append(keys, "uid:"+rejectedErr.UID)
+	}
+	return keys
+}
+
+func (l *Lockout) clientIP(ctx *gin.Context) string {
+	return requestIP(ctx, l.config.TrustForwardedFor)
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/let-commerce/backend-common/response"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	mfaTable              = "consumers.back_office_user_totps"
+	defaultMFAMaxWindow   = 12 * time.Hour
+	mfaVerifiedKeyPattern = "auth:mfa:verified:%v:%v"
+)
+
+// BackOfficeUserTOTP is the TOTP second factor of a backoffice user, migrated by the consumers service (the secret is stored encrypted)
+type BackOfficeUserTOTP struct {
+	BackOfficeUserID uint `gorm:"primaryKey"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	EncryptedSecret  string     `gorm:"not null"`
+	ConfirmedAt      *time.Time // nil until the user verified a first code
+	LastUsedStep     int64      // the time step of the last accepted code, codes can't be replayed
+}
+
+// MFA enrolls and verifies TOTP second factors of backoffice users, and guards sensitive routes with RequireRecentMFA
+type MFA struct {
+	DB            *gorm.DB
+	Redis         *redigo.Pool  // recent verifications, shared between all replicas
+	EncryptionKey []byte        // AES key (16, 24 or 32 bytes) encrypting the secrets at rest
+	Issuer        string        // shown in the authenticator app, e.g. "Let Commerce Backoffice"
+	MaxWindow     time.Duration // how long a verification is kept, the longest window of RequireRecentMFA (defaults to 12h)
+}
+
+// NewMFA creates an MFA with the given encryption key
+func NewMFA(db *gorm.DB, pool *redigo.Pool, encryptionKey []byte, issuer string) (*MFA, error) {
+	if _, err := newGCM(encryptionKey); err != nil {
+		return nil, err
+	}
+	return &MFA{DB: db, Redis: pool, EncryptionKey: encryptionKey, Issuer: issuer, MaxWindow: defaultMFAMaxWindow}, nil
+}
+
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"` // otpauth:// url, to be shown as a QR code
+}
+
+// Enroll creates (or replaces an unconfirmed) TOTP secret of the backoffice user, confirmed by a first Verify
+func (m *MFA) Enroll(ctx context.Context, backofficeUserId uint, accountName string) (MFAEnrollment, error) {
+	existing, err := m.getTOTP(ctx, backofficeUserId)
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	if existing.ConfirmedAt != nil {
+		return MFAEnrollment{}, errors.Errorf("backoffice user %v is already enrolled", backofficeUserId)
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	encryptedSecret, err := encryptSecret(m.EncryptionKey, secret)
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	totp := BackOfficeUserTOTP{BackOfficeUserID: backofficeUserId, EncryptedSecret: encryptedSecret}
+	if err = m.DB.WithContext(ctx).Table(mfaTable).Clauses(clause.OnConflict{UpdateAll: true}).Create(&totp).Error; err != nil {
+		return MFAEnrollment{}, errors.Wrapf(err, "can't save totp of backoffice user %v", backofficeUserId)
+	}
+	return MFAEnrollment{Secret: secret, URL: totpURL(m.Issuer, accountName, secret)}, nil
+}
+
+// Verify checks the code of the backoffice user (confirming a pending enrollment), and records the verification of the session
+func (m *MFA) Verify(ctx context.Context, p *Principal, code string) (bool, error) {
+	totp, err := m.getTOTP(ctx, p.BackofficeUserID)
+	if err != nil || totp.EncryptedSecret == "" {
+		return false, err
+	}
+	secret, err := decryptSecret(m.EncryptionKey, totp.EncryptedSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok || step <= totp.LastUsedStep {
+		return false, nil
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"last_used_step": step}
+	if totp.ConfirmedAt == nil {
+		updates["confirmed_at"] = now
+	}
+	// conditional update, so a code can't be used twice by concurrent requests
+	result := m.DB.WithContext(ctx).Table(mfaTable).Where("back_office_user_id = ? AND last_used_step < ?", p.BackofficeUserID, step).Updates(updates)
+	if result.Error != nil {
+		return false, errors.Wrapf(result.Error, "can't update totp of backoffice user %v", p.BackofficeUserID)
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, m.setVerified(p, now)
+}
+
+// Disable removes the TOTP second factor of the backoffice user (e.g. a lost device, by an admin)
+func (m *MFA) Disable(ctx context.Context, backofficeUserId uint) error {
+	if err := m.DB.WithContext(ctx).Table(mfaTable).Delete(&BackOfficeUserTOTP{}, backofficeUserId).Error; err != nil {
+		return errors.Wrapf(err, "can't delete totp of backoffice user %v", backofficeUserId)
+	}
+	return nil
+}
+
+// IsEnrolled reports whether the backoffice user has a confirmed TOTP second factor
+func (m *MFA) IsEnrolled(ctx context.Context, backofficeUserId uint) (bool, error) {
+	totp, err := m.getTOTP(ctx, backofficeUserId)
+	return totp.ConfirmedAt != nil, err
+}
+
+// RequireRecentMFA : to verify the backoffice user verified a TOTP code in the given window (in the current sign-in session).
+// Usage: router.POST("/orders/:id/refund", auth.RequireAdminAuth, mfa.RequireRecentMFA(5*time.Minute), handler)
+func (m *MFA) RequireRecentMFA(window time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		p, ok := PrincipalFrom(ctx)
+		if !ok || !p.IsBackofficeUser() {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. MFA is only supported for backoffice users.")})
+			ctx.Abort()
+			return
+		}
+		verifiedAt, err := m.verifiedAt(p)
+		if err != nil {
+			log.Errorf("Got error while getting mfa verification of backoffice user %v: %v", p.BackofficeUserID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Authorization Error. Can't check MFA verification.")})
+			ctx.Abort()
+			return
+		}
+		if verifiedAt.IsZero() || time.Since(verifiedAt) > window {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. MFA verification required."), "mfaRequired": true})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+type mfaVerifyRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// RegisterRoutes mounts the enrollment and verification api of the authenticated backoffice user on the given group
+// Usage: mfa.RegisterRoutes(router.Group("/mfa", auth.Realm(auth.Backoffice), authenticator.AuthMiddleware, authenticator.RequireAuth))
+func (m *MFA) RegisterRoutes(group *gin.RouterGroup) {
+	group.POST("/enroll", m.handleEnroll)
+	group.POST("/verify", m.handleVerify)
+	group.DELETE("/:id", RequireAdminAuth, m.RequireRecentMFA(5*time.Minute), m.handleDisable)
+}
+
+func (m *MFA) handleEnroll(ctx *gin.Context) {
+	p, ok := PrincipalFrom(ctx)
+	if !ok || !p.IsBackofficeUser() {
+		ctx.JSON(http.StatusForbidden, response.NewErrorMessageResponse("Only backoffice users can enroll MFA"))
+		return
+	}
+	enrollment, err := m.Enroll(ctx, p.BackofficeUserID, p.Email)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorResponse("Got error while enrolling MFA", err))
+		return
+	}
+	ctx.JSON(http.StatusOK, enrollment)
+}
+
+func (m *MFA) handleVerify(ctx *gin.Context) {
+	p, ok := PrincipalFrom(ctx)
+	if !ok || !p.IsBackofficeUser() {
+		ctx.JSON(http.StatusForbidden, response.NewErrorMessageResponse("Only backoffice users can verify MFA"))
+		return
+	}
+	var req mfaVerifyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorResponse("Got error while binding MFA code", errors.WithStack(err)))
+		return
+	}
+	verified, err := m.Verify(ctx, p, req.Code)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, response.NewErrorResponse("Got error while verifying MFA code", err))
+		return
+	}
+	if !verified {
+		log.Warnf("Got invalid MFA code of backoffice user %v", p.BackofficeUserID)
+		ctx.JSON(http.StatusUnauthorized, response.NewErrorMessageResponse("Invalid MFA code"))
+		return
+	}
+	ctx.JSON(http.StatusOK, response.Response{Message: "MFA verified"})
+}
+
+func (m *MFA) handleDisable(ctx *gin.Context) {
+	paramVal := ctx.Params.ByName("id")
+	id, err := strconv.ParseUint(paramVal, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, response.NewErrorResponseF(errors.WithStack(err), "can't bind param: id to uint (value = %v)", paramVal))
+		return
+	}
+	if err = m.Disable(ctx, uint(id)); err != nil {
+		ctx.JSON(http.StatusInternalServerError, response.NewErrorResponse("Got error while disabling MFA", err))
+		return
+	}
+	ctx.JSON(http.StatusOK, response.Response{Message: "MFA disabled", ID: uint(id)})
+}
+
+func (m *MFA) getTOTP(ctx context.Context, backofficeUserId uint) (BackOfficeUserTOTP, error) {
+	var totp BackOfficeUserTOTP
+	err := m.DB.WithContext(ctx).Table(mfaTable).Where("back_office_user_id = ?", backofficeUserId).Limit(1).Find(&totp).Error
+	if err != nil {
+		return BackOfficeUserTOTP{}, errors.Wrapf(err, "can't get totp of backoffice user %v", backofficeUserId)
+	}
+	return totp, nil
+}
+
+func (m *MFA) setVerified(p *Principal, verifiedAt time.Time) error {
+	conn := m.Redis.Get()
+	defer conn.Close()
+	if _, err := conn.Do("SET", mfaVerifiedKey(p), verifiedAt.Unix(), "EX", int64(m.maxWindow().Seconds())); err != nil {
+		return errors.Wrap(err, "can't record mfa verification")
+	}
+	return nil
+}
+
+func (m *MFA) verifiedAt(p *Principal) (time.Time, error) {
+	conn := m.Redis.Get()
+	defer conn.Close()
+	verifiedAt, err := redigo.Int64(conn.Do("GET", mfaVerifiedKey(p)))
+	if err == redigo.ErrNil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(verifiedAt, 0), nil
+}
+
+func (m *MFA) maxWindow() time.Duration {
+	if m.MaxWindow == 0 {
+		return defaultMFAMaxWindow
+	}
+	return m.MaxWindow
+}
+
+// mfaVerifiedKey binds a verification to the sign-in session (the firebase "auth_time" claim survives token refreshes)
+func mfaVerifiedKey(p *Principal) string {
+	var authTime int64
+	if value, ok := p.Claims["auth_time"].(float64); ok {
+		authTime = int64(value)
+	}
+	return fmt.Sprintf(mfaVerifiedKeyPattern, p.BackofficeUserID, authTime)
+}
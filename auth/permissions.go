@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+// BackOfficeRole is a named set of permissions (e.g. "support", "warehouse", "finance"), migrated by the consumers service
+type BackOfficeRole struct {
+	ID          uint   `gorm:"primarykey"`
+	Name        string `gorm:"uniqueIndex;not null"`
+	Description string
+	Permissions []BackOfficeRolePermission `gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+}
+
+// BackOfficeRolePermission is a single permission of a role, in "resource:action" format (e.g. "orders:refund", "orders:*")
+type BackOfficeRolePermission struct {
+	RoleID     uint   `gorm:"primaryKey"`
+	Permission string `gorm:"primaryKey"`
+}
+
+// BackOfficeUserRole assigns a role to a backoffice user
+type BackOfficeUserRole struct {
+	BackOfficeUserID uint           `gorm:"primaryKey"`
+	RoleID           uint           `gorm:"primaryKey"`
+	Role             BackOfficeRole `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+type backofficeUserRolePermission struct {
+	Role       string
+	Permission *string
+}
+
+// RequirePermission : to verify the backoffice user has all the given permissions (admins have all permissions).
+// The permissions are loaded only by a resolver with roles enabled, e.g. authenticator.BackofficeUserResolver = &auth.SQLResolver{DB: db, Roles: true}
+// Usage: router.POST("/orders/:id/refund", auth.RequirePermission("orders:refund"), handler)
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		for _, permission := range permissions {
+			if !HasPermission(ctx, permission) {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. Missing permission: %v.", permission)})
+				ctx.Abort()
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// permissionMatches checks if the granted permission covers the required one, supporting "*" and "resource:*" wildcards
+func permissionMatches(granted string, required string) bool {
+	if granted == "*" || granted == required {
+		return true
+	}
+	if strings.HasSuffix(granted, ":*") {
+		return strings.HasPrefix(required, strings.TrimSuffix(granted, "*"))
+	}
+	return false
+}
+
+func loadBackofficeUserRoles(db *gorm.DB, backofficeUserId uint) (roles []string, permissions []string, err error) {
+	var rows []backofficeUserRolePermission
+	err = db.Raw(`SELECT r.name AS role, p.permission FROM consumers.back_office_user_roles ur
+		JOIN consumers.back_office_roles r ON r.id = ur.role_id
+		LEFT JOIN consumers.back_office_role_permissions p ON p.role_id = r.id
+		WHERE ur.back_office_user_id = ?`, backofficeUserId).Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seenRoles := map[string]bool{}
+	for _, row := range rows {
+		if !seenRoles[row.Role] {
+			seenRoles[row.Role] = true
+			roles = append(roles, row.Role)
+		}
+		if row.Permission != nil {
+			permissions = append(permissions, *row.Permission)
+		}
+	}
+	return roles, permissions, nil
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth/principal"
+)
+
+// Principal is the authenticated caller of a request
+type Principal = principal.Principal
+
+// RealmName is the kind of identity a principal was authenticated as
+type RealmName = principal.Realm
+
+const (
+	Consumer    = principal.Consumer
+	Backoffice  = principal.Backoffice
+	Service     = principal.Service
+	APIKeyRealm = principal.APIKey
+)
+
+// PrincipalFrom returns the principal of the current request, from a gin context or from the request context.Context (e.g. in repositories and workers)
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	return principal.FromContext(ctx)
+}
+
+// WithPrincipal returns a copy of ctx carrying the given principal (e.g. to act on behalf of a user in a worker)
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return principal.NewContext(ctx, p)
+}
+
+// SetPrincipal stores the principal in the gin context and in the request context (for custom auth middlewares and tests).
+// The legacy identity keys are still set for services reading them directly.
+func SetPrincipal(ctx *gin.Context, p *Principal) {
+	ctx.Set(principal.GinKey, p)
+	if ctx.Request != nil {
+		ctx.Request = ctx.Request.WithContext(principal.NewContext(ctx.Request.Context(), p))
+	}
+
+	ctx.Set("FIREBASE_USER_UID", p.UID)
+	if p.Email != "" {
+		ctx.Set("FIREBASE_USER_EMAIL", p.Email)
+	}
+	if p.ConsumerID != 0 {
+		ctx.Set("AUTHENTICATED_CONSUMER_ID", p.ConsumerID)
+		ctx.Set("IS_GUEST", p.IsGuest)
+	}
+	if p.BackofficeUserID != 0 {
+		ctx.Set("AUTHENTICATED_BACKOFFICE_USER_ID", p.BackofficeUserID)
+		ctx.Set("IS_ADMIN", p.IsAdmin)
+	}
+}
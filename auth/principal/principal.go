@@ -0,0 +1,119 @@
+// Package principal contains the identity of the caller of a request, shared by the auth, logs and ginutils packages
+// Usage: p, ok := auth.PrincipalFrom(ctx)
+package principal
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+)
+
+// GinKey is the gin context key the principal is stored under
+const GinKey = "AUTH_PRINCIPAL"
+
+// Realm is the kind of identity a principal was authenticated as
+type Realm string
+
+const (
+	Consumer   Realm = "consumer"
+	Backoffice Realm = "backoffice"
+	Service    Realm = "service"
+	APIKey     Realm = "api_key"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UID              string                 `json:"uid,omitempty"`
+	Email            string                 `json:"email,omitempty"`
+	Realm            Realm                  `json:"realm,omitempty"`
+	ConsumerID       uint                   `json:"consumerId,omitempty"`
+	IsGuest          bool                   `json:"isGuest,omitempty"`
+	BackofficeUserID uint                   `json:"backofficeUserId,omitempty"`
+	IsAdmin          bool                   `json:"isAdmin,omitempty"`
+	Roles            []string               `json:"roles,omitempty"`
+	ServiceName      string                 `json:"serviceName,omitempty"`    // the calling service, for service realm principals
+	APIKeyID         uint                   `json:"apiKeyId,omitempty"`       // the api key used, for api key realm principals
+	Scopes           []string               `json:"scopes,omitempty"`         // the api key scopes
+	ImpersonatorID   uint                   `json:"impersonatorId,omitempty"` // the backoffice user impersonating the consumer, if any
+	TenantID         uint                   `json:"tenantId,omitempty"`       // the store / merchant the request is made for
+	TenantIDs        []uint                 `json:"-"`                        // the tenants assigned to the backoffice user (or to the impersonator)
+	AllTenants       bool                   `json:"-"`                        // a platform admin (or impersonated by one), with access to every tenant
+	Permissions      []string               `json:"-"`
+	Claims           map[string]interface{} `json:"-"` // the verified token claims
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored in the given context (gin context or request context)
+func FromContext(ctx context.Context) (*Principal, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	if ginCtx, ok := ctx.(*gin.Context); ok {
+		if value, exists := ginCtx.Get(GinKey); exists {
+			p, ok := value.(*Principal)
+			return p, ok && p != nil
+		}
+		if ginCtx.Request == nil {
+			return nil, false
+		}
+		ctx = ginCtx.Request.Context()
+	}
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// IsConsumer reports whether the principal is an authenticated consumer (including guests)
+func (p *Principal) IsConsumer() bool {
+	return p.ConsumerID != 0
+}
+
+// IsBackofficeUser reports whether the principal is an authenticated backoffice user
+func (p *Principal) IsBackofficeUser() bool {
+	return p.BackofficeUserID != 0
+}
+
+// IsService reports whether the principal is another internal service
+func (p *Principal) IsService() bool {
+	return p.Realm == Service && p.ServiceName != ""
+}
+
+// IsImpersonated reports whether a backoffice user is acting as the consumer
+func (p *Principal) IsImpersonated() bool {
+	return p.ImpersonatorID != 0
+}
+
+// HasRole reports whether the principal has the given backoffice role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessTenant reports whether the principal may access the data of the given tenant.
+// Backoffice users are limited to their assigned tenants, only platform admins (AllTenants) can access every tenant.
+// Impersonated consumers are limited to the tenants of the impersonating admin. Backoffice users with no tenancy loaded can't access any tenant.
+func (p *Principal) CanAccessTenant(tenantId uint) bool {
+	if tenantId == 0 {
+		return false
+	}
+	if !p.IsBackofficeUser() && !p.IsImpersonated() { // consumers, services and api keys are not bound to a tenant
+		return true
+	}
+	if p.AllTenants {
+		return true
+	}
+	for _, id := range p.TenantIDs {
+		if id == tenantId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package principal
+
+import "testing"
+
+func TestCanAccessTenant(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal Principal
+		tenantId  uint
+		expected  bool
+	}{
+		{"consumer", Principal{ConsumerID: 7}, 2, true},
+		{"platform admin", Principal{BackofficeUserID: 3, IsAdmin: true, AllTenants: true}, 2, true},
+		{"admin without tenancy", Principal{BackofficeUserID: 3, IsAdmin: true}, 2, false},
+		{"assigned backoffice user", Principal{BackofficeUserID: 3, TenantIDs: []uint{1, 2}}, 2, true},
+		{"unassigned backoffice user", Principal{BackofficeUserID: 3, TenantIDs: []uint{1}}, 2, false},
+		{"tenant admin", Principal{BackofficeUserID: 3, IsAdmin: true, TenantIDs: []uint{1}}, 2, false},
+		{"impersonated by a platform admin", Principal{ConsumerID: 7, ImpersonatorID: 3, AllTenants: true}, 2, true},
+		{"impersonated without tenancy", Principal{ConsumerID: 7, ImpersonatorID: 3}, 2, false},
+		{"impersonated by an admin of the tenant", Principal{ConsumerID: 7, ImpersonatorID: 3, TenantIDs: []uint{2}}, 2, true},
+		{"impersonated by an admin of another tenant", Principal{ConsumerID: 7, ImpersonatorID: 3, TenantIDs: []uint{1}}, 2, false},
+		{"no tenant", Principal{ConsumerID: 7}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.principal.CanAccessTenant(test.tenantId); actual != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/env"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const (
+	requestContextHeader = "RequestContext"
+	realmKey             = "AUTH_REALM"
+)
+
+// RealmHeaderPolicy decides how the client supplied RequestContext header is treated
+type RealmHeaderPolicy int
+
+const (
+	TrustRealmHeader      RealmHeaderPolicy = iota // the header picks the realm of routes without a bound realm, ignored on bound routes (legacy)
+	IgnoreRealmHeader                              // the header is never used, routes without a bound realm are consumer routes
+	CrossCheckRealmHeader                          // as TrustRealmHeader, but a header not matching the bound realm of the route is rejected
+)
+
+// Realm : binds the realm tokens are verified against to a router group, instead of the client RequestContext header
+// Usage: admin := router.Group("/admin", auth.Realm(auth.Backoffice), authenticator.AuthMiddleware, authenticator.RequireAuth)
+func Realm(realm RealmName) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(realmKey, realm)
+		ctx.Next()
+	}
+}
+
+// requestRealm returns the realm of the request by the route binding and the header policy, returns false if the request was aborted
+func (a *Authenticator) requestRealm(ctx *gin.Context) (RealmName, bool) {
+	header := ctx.GetHeader(requestContextHeader)
+	value, bound := ctx.Get(realmKey)
+	if !bound {
+		if a.HeaderPolicy == IgnoreRealmHeader {
+			return Consumer, true
+		}
+		return realmFromRequestContext(header), true
+	}
+
+	realm := value.(RealmName)
+	if a.HeaderPolicy == CrossCheckRealmHeader && header != "" && realmFromRequestContext(header) != realm {
+		log.Warnf("Got %v header %v on a %v route", requestContextHeader, header, realm)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error - %v header doesn't match the route (%v)", requestContextHeader, env.GetEnvVar("SERVICE_NAME"))})
+		ctx.Abort()
+		return "", false
+	}
+	return realm, true
+}
+
+func realmFromRequestContext(requestContext string) RealmName {
+	switch requestContext {
+	case "Backoffice":
+		return Backoffice
+	case "Service":
+		return Service
+	default:
+		return Consumer
+	}
+}
@@ -0,0 +1,27 @@
+package auth_test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/auth/authtest"
+	"net/http"
+	"testing"
+)
+
+func TestRealmHeaderPolicies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := authtest.NewMinter()
+	authenticator := minter.Authenticator()
+	authenticator.HeaderPolicy = auth.CrossCheckRealmHeader
+	router := gin.New()
+	router.GET("/admin", auth.Realm(auth.Backoffice), authenticator.AuthMiddleware, authenticator.RequireAuth, ok)
+
+	token := minter.BackofficeToken(t, 3, true)
+	authtest.AssertStatus(t, serve(router, token, auth.Backoffice, "/admin"), http.StatusOK)
+	authtest.AssertUnauthorized(t, serve(router, token, auth.Service, "/admin"), "RequestContext")
+
+	authenticator.HeaderPolicy = auth.IgnoreRealmHeader
+	router = gin.New()
+	router.GET("/internal", authenticator.AuthMiddleware, auth.RequireService("orders"), ok)
+	authtest.AssertForbidden(t, serve(router, minter.ServiceToken(t, "orders"), auth.Service, "/internal"))
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// UserIdentity is the verified token identity a consumer / backoffice user is resolved by
+type UserIdentity struct {
+	UID         string
+	email       string
+	lookupEmail func(ctx context.Context) (string, error)
+}
+
+// NewUserIdentity creates a UserIdentity with a known email (e.g. in tests or workers)
+func NewUserIdentity(uid string, email string) UserIdentity {
+	return UserIdentity{UID: uid, email: email}
+}
+
+// Email returns the user email, from the token claims or (lazily) from the token issuer
+func (i *UserIdentity) Email(ctx context.Context) (string, error) {
+	if i.email == "" && i.lookupEmail != nil {
+		email, err := i.lookupEmail(ctx)
+		if err != nil {
+			return "", err
+		}
+		i.email = email
+	}
+	return i.email, nil
+}
+
+type GetBackofficeUserResult struct {
+	ID          uint
+	IsAdmin     bool
+	Roles       []string
+	Permissions []string
+	TenantIDs   []uint
+	AllTenants  bool // a platform admin, set only when the tenants are loaded
+}
+
+type GetConsumerResult struct {
+	ID      uint
+	IsGuest bool
+}
+
+// ConsumerResolver resolves the consumer of a verified token, a zero result means not found
+type ConsumerResolver interface {
+	ResolveConsumer(ctx context.Context, identity UserIdentity) (GetConsumerResult, error)
+	GetConsumer(ctx context.Context, consumerId uint) (GetConsumerResult, error)
+}
+
+// BackofficeUserResolver resolves the backoffice user (with its roles and permissions) of a verified token, a zero result means not found
+type BackofficeUserResolver interface {
+	ResolveBackofficeUser(ctx context.Context, identity UserIdentity) (GetBackofficeUserResult, error)
+	GetBackofficeUser(ctx context.Context, backofficeUserId uint) (GetBackofficeUserResult, error)
+}
+
+// SQLResolver is the default ConsumerResolver and BackofficeUserResolver, on top of the consumers schema
+type SQLResolver struct {
+	DB            *gorm.DB
+	LookupByUID   bool   // look up by the UIDColumn first (falling back to email), requires the column in both tables
+	UIDColumn     string // defaults to "firebase_uid"
+	AutoProvision bool   // create a guest consumer the first time a valid token of an unknown user is seen (requires an email or LookupByUID, and a unique index on the UIDColumn with LookupByUID, or on email)
+	Roles         bool   // load the roles and permissions of backoffice users, requires the consumers.back_office_roles, back_office_user_roles and back_office_role_permissions tables
+	DisabledUsers bool   // skip backoffice users disabled by BackofficeAdmin.SetDisabled, requires the consumers.back_office_users.disabled_at column
+	Tenancy       bool   // load the tenants assigned to backoffice users (admins with none are platform admins), requires the consumers.back_office_user_tenants table (see RequireTenantAccess)
+}
+
+// NewSQLResolver creates an SQLResolver looking up consumers and backoffice users by email
+func NewSQLResolver(db *gorm.DB) *SQLResolver {
+	return &SQLResolver{DB: db}
+}
+
+func (r *SQLResolver) ResolveConsumer(ctx context.Context, identity UserIdentity) (GetConsumerResult, error) {
+	var result GetConsumerResult
+	db := r.DB.WithContext(ctx)
+	if r.LookupByUID {
+		if err := db.Raw("SELECT id, is_guest FROM consumers.consumers WHERE "+r.uidColumn()+" = ?", identity.UID).Scan(&result).Error; err != nil || result.ID != 0 {
+			return result, errors.WithStack(err)
+		}
+	}
+
+	email, err := identity.Email(ctx)
+	if err != nil {
+		return result, err
+	}
+	if email != "" {
+		if err = db.Raw("SELECT id, is_guest FROM consumers.consumers WHERE email = ?", email).Scan(&result).Error; err != nil || result.ID != 0 {
+			return result, errors.WithStack(err)
+		}
+	}
+	if r.AutoProvision && (email != "" || r.LookupByUID) {
+		return r.provisionGuestConsumer(db, identity.UID, email)
+	}
+	return result, nil
+}
+
+func (r *SQLResolver) GetConsumer(ctx context.Context, consumerId uint) (GetConsumerResult, error) {
+	var result GetConsumerResult
+	err := r.DB.WithContext(ctx).Raw("SELECT id, is_guest FROM consumers.consumers WHERE id = ?", consumerId).Scan(&result).Error
+	return result, errors.WithStack(err)
+}
+
+func (r *SQLResolver) ResolveBackofficeUser(ctx context.Context, identity UserIdentity) (GetBackofficeUserResult, error) {
+	var result GetBackofficeUserResult
+	db := r.DB.WithContext(ctx)
+	if r.LookupByUID {
+		if err := db.Raw("SELECT id, is_admin FROM consumers.back_office_users WHERE "+r.uidColumn()+" = ?"+r.activeBackofficeUsers(), identity.UID).Scan(&result).Error; err != nil {
+			return result, errors.WithStack(err)
+		}
+	}
+	if result.ID == 0 {
+		email, err := identity.Email(ctx)
+		if err != nil || email == "" {
+			return result, err
+		}
+		if err = db.Raw("SELECT id, is_admin FROM consumers.back_office_users WHERE email = ?"+r.activeBackofficeUsers(), email).Scan(&result).Error; err != nil || result.ID == 0 {
+			return result, errors.WithStack(err)
+		}
+	}
+	return r.withRoles(db, result)
+}
+
+func (r *SQLResolver) GetBackofficeUser(ctx context.Context, backofficeUserId uint) (GetBackofficeUserResult, error) {
+	var result GetBackofficeUserResult
+	db := r.DB.WithContext(ctx)
+	if err := db.Raw("SELECT id, is_admin FROM consumers.back_office_users WHERE id = ?"+r.activeBackofficeUsers(), backofficeUserId).Scan(&result).Error; err != nil || result.ID == 0 {
+		return result, errors.WithStack(err)
+	}
+	return r.withRoles(db, result)
+}
+
+// withRoles loads the roles and tenants of the backoffice user, when enabled
+func (r *SQLResolver) withRoles(db *gorm.DB, result GetBackofficeUserResult) (GetBackofficeUserResult, error) {
+	var err error
+	if r.Roles {
+		result.Roles, result.Permissions, err = loadBackofficeUserRoles(db, result.ID)
+		if err != nil {
+			return GetBackofficeUserResult{}, errors.Wrapf(err, "can't get roles of backoffice user %v", result.ID)
+		}
+	}
+	if !r.Tenancy {
+		return result, nil
+	}
+	result.TenantIDs, err = loadBackofficeUserTenants(db, result.ID)
+	if err != nil {
+		return GetBackofficeUserResult{}, errors.Wrapf(err, "can't get tenants of backoffice user %v", result.ID)
+	}
+	result.AllTenants = result.IsAdmin && len(result.TenantIDs) == 0 // admins without assigned tenants are platform admins
+	return result, nil
+}
+
+// provisionGuestConsumer creates the guest consumer of the user, or returns the one created meanwhile by a concurrent request of the same user.
+// The ON CONFLICT clause requires a unique index on the conflict column, postgres rejects the insert without one.
+func (r *SQLResolver) provisionGuestConsumer(db *gorm.DB, uid string, email string) (GetConsumerResult, error) {
+	now := time.Now()
+	columns := []string{"is_guest", "created_at", "updated_at"}
+	values := []interface{}{true, now, now}
+	if email != "" {
+		columns, values = append(columns, "email"), append(values, email)
+	}
+	conflictColumn, conflictValue := "email", interface{}(email)
+	if r.LookupByUID {
+		columns, values = append(columns, r.uidColumn()), append(values, uid)
+		conflictColumn, conflictValue = r.uidColumn(), uid
+	}
+
+	var result GetConsumerResult
+	query := fmt.Sprintf("INSERT INTO consumers.consumers (%v) VALUES (?%v) ON CONFLICT (%v) DO NOTHING RETURNING id, is_guest",
+		strings.Join(columns, ", "), strings.Repeat(", ?", len(values)-1), conflictColumn)
+	if err := db.Raw(query, values...).Scan(&result).Error; err != nil {
+		return GetConsumerResult{}, errors.Wrapf(err, "can't provision guest consumer for uid %v", uid)
+	}
+	if result.ID == 0 { // already provisioned
+		err := db.Raw("SELECT id, is_guest FROM consumers.consumers WHERE "+conflictColumn+" = ?", conflictValue).Scan(&result).Error
+		return result, errors.Wrapf(err, "can't get provisioned guest consumer for uid %v", uid)
+	}
+	log.Infof("Provisioned guest consumer %v for uid %v", result.ID, uid)
+	return result, nil
+}
+
+// activeBackofficeUsers is the condition skipping disabled backoffice users, when enabled
+func (r *SQLResolver) activeBackofficeUsers() string {
+	if !r.DisabledUsers {
+		return ""
+	}
+	return " AND disabled_at IS NULL"
+}
+
+func (r *SQLResolver) uidColumn() string {
+	if r.UIDColumn == "" {
+		return "firebase_uid"
+	}
+	return r.UIDColumn
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sqlRecorder is a gorm logger recording the statements of a dry run DB
+type sqlRecorder struct {
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface      { return r }
+func (r *sqlRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+func (r *sqlRecorder) contains(text string) bool {
+	for _, statement := range r.statements {
+		if strings.Contains(statement, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func dryRunDB(t *testing.T) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: recorder})
+	if err != nil {
+		t.Fatalf("can't open dry run db: %v", err)
+	}
+	return db, recorder
+}
+
+// emptyDriver is a database/sql driver answering every query with no rows, so queries run (unlike a dry run DB) but find nothing
+type emptyDriver struct{}
+type emptyConn struct{}
+type emptyStmt struct{}
+type emptyRows struct{}
+
+func (emptyDriver) Open(string) (driver.Conn, error) { return emptyConn{}, nil }
+
+func (emptyConn) Prepare(string) (driver.Stmt, error) { return emptyStmt{}, nil }
+func (emptyConn) Close() error                        { return nil }
+func (emptyConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (emptyStmt) Close() error                               { return nil }
+func (emptyStmt) NumInput() int                              { return -1 }
+func (emptyStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (emptyStmt) Query([]driver.Value) (driver.Rows, error)  { return emptyRows{}, nil }
+func (emptyRows) Columns() []string                          { return []string{"id", "is_guest"} }
+func (emptyRows) Close() error                               { return nil }
+func (emptyRows) Next([]driver.Value) error                  { return io.EOF }
+
+func init() {
+	sql.Register("auth-test-empty", emptyDriver{})
+}
+
+func emptyDB(t *testing.T) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{DriverName: "auth-test-empty"}), &gorm.Config{DisableAutomaticPing: true, Logger: recorder})
+	if err != nil {
+		t.Fatalf("can't open empty db: %v", err)
+	}
+	return db, recorder
+}
+
+func TestSQLResolverLoadsRolesOnlyWhenEnabled(t *testing.T) {
+	db, recorder := dryRunDB(t)
+	if _, err := (&SQLResolver{DB: db}).withRoles(db, GetBackofficeUserResult{ID: 3}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recorder.statements) != 0 {
+		t.Fatalf("expected no roles or tenants query, got: %v", recorder.statements)
+	}
+
+	(&SQLResolver{DB: db, Roles: true}).withRoles(db, GetBackofficeUserResult{ID: 3}) // a dry run can't scan, only the statements are checked
+	if !recorder.contains("consumers.back_office_user_roles") || recorder.contains("back_office_user_tenants") {
+		t.Fatalf("expected only the roles query, got: %v", recorder.statements)
+	}
+}
+
+func TestSQLResolverSkipsDisabledUsersOnlyWhenEnabled(t *testing.T) {
+	db, recorder := dryRunDB(t)
+	(&SQLResolver{DB: db}).GetBackofficeUser(context.Background(), 3)
+	if recorder.contains("disabled_at") {
+		t.Fatalf("expected no disabled_at condition, got: %v", recorder.statements)
+	}
+
+	(&SQLResolver{DB: db, DisabledUsers: true}).GetBackofficeUser(context.Background(), 3)
+	if !recorder.contains("disabled_at IS NULL") {
+		t.Fatalf("expected a disabled_at condition, got: %v", recorder.statements)
+	}
+}
+
+func TestSQLResolverRereadsConcurrentlyProvisionedConsumer(t *testing.T) {
+	db, recorder := emptyDB(t)
+	resolver := &SQLResolver{DB: db, LookupByUID: true, AutoProvision: true}
+	if _, err := resolver.ResolveConsumer(context.Background(), NewUserIdentity("user-1", "")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the insert returns no row when a concurrent request provisioned the consumer first, it is then read by the conflict column
+	if !recorder.contains("ON CONFLICT (firebase_uid) DO NOTHING") {
+		t.Fatalf("expected the provisioning insert, got: %v", recorder.statements)
+	}
+	last := recorder.statements[len(recorder.statements)-1]
+	if !strings.HasPrefix(last, "SELECT id, is_guest FROM consumers.consumers WHERE firebase_uid = ") {
+		t.Fatalf("expected the provisioned consumer to be read again, got: %v", recorder.statements)
+	}
+}
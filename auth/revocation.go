@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"github.com/pkg/errors"
+)
+
+// UserStatus is the cached revocation / disabled state of a firebase user
+type UserStatus struct {
+	Disabled               bool
+	TokensValidAfterMillis int64
+}
+
+// TokenRevoker is the subset of the firebase auth client used to revoke tokens (implemented by *auth.Client and *auth.TenantClient)
+type TokenRevoker interface {
+	RevokeRefreshTokens(ctx context.Context, uid string) error
+}
+
+// RevokeUser revokes all the refresh tokens of the given user, and evicts every cached entry of its uid.
+// Already issued id tokens are rejected by verifiers with CheckRevoked (on all replicas when a shared cache tier is configured).
+func RevokeUser(ctx context.Context, client TokenRevoker, uid string) error {
+	if err := client.RevokeRefreshTokens(ctx, uid); err != nil {
+		return errors.Wrapf(err, "can't revoke refresh tokens of user %v", uid)
+	}
+	Invalidate(uid)
+	return nil
+}
+
+// checkUserStatus checks the user is not disabled and the token was issued after the last revocation
+func checkUserStatus(ctx context.Context, client FirebaseClient, uid string, issuedAt int64) error {
+	status, cached := UserStatusCache.Get(uid)
+	if !cached {
+		userRecord, err := client.GetUser(ctx, uid)
+		if err != nil {
+			return errors.Wrapf(err, "can't check revocation of user %v", uid)
+		}
+		status = UserStatus{Disabled: userRecord.Disabled, TokensValidAfterMillis: userRecord.TokensValidAfterMillis}
+		UserStatusCache.Set(uid, status)
+	}
+
+	if status.Disabled {
+		return &RejectedTokenError{UID: uid, Err: errors.Errorf("user %v is disabled", uid)}
+	}
+	if issuedAt*1000 < status.TokensValidAfterMillis {
+		return &RejectedTokenError{UID: uid, Err: errors.Errorf("id token of user %v has been revoked", uid)}
+	}
+	return nil
+}
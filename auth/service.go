@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+// ServiceClaim is the claim holding the calling service name in service tokens
+const ServiceClaim = "service"
+
+// GoogleOIDCJWKSURL and GoogleOIDCIssuer are used to verify google signed OIDC identity tokens of service accounts
+const (
+	GoogleOIDCJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+	GoogleOIDCIssuer  = "https://accounts.google.com"
+)
+
+// GoogleOIDCServiceVerifier verifies google signed OIDC identity tokens of an allowlist of service accounts.
+// Any google service account (of any project) can get a token for any audience, so the verified email must be in the allowlist.
+type GoogleOIDCServiceVerifier struct {
+	*JWTVerifier
+	ServiceAccounts map[string]string // service account email -> service name
+}
+
+// NewGoogleOIDCServiceVerifier creates a service token verifier for google signed OIDC identity tokens issued for the given audience,
+// to the given service accounts (email -> service name, required)
+// Usage: verifier, err := auth.NewGoogleOIDCServiceVerifier(audience, map[string]string{"orders@my-project.iam.gserviceaccount.com": "orders"})
+//
+//	authenticator.WithVerifier(auth.Service, verifier)
+func NewGoogleOIDCServiceVerifier(audience string, serviceAccounts map[string]string) (*GoogleOIDCServiceVerifier, error) {
+	if len(serviceAccounts) == 0 {
+		return nil, errors.New("google oidc service verifier requires an allowlist of service accounts")
+	}
+	return &GoogleOIDCServiceVerifier{JWTVerifier: NewJWKSVerifier(GoogleOIDCJWKSURL, GoogleOIDCIssuer, audience), ServiceAccounts: serviceAccounts}, nil
+}
+
+func (v *GoogleOIDCServiceVerifier) VerifyToken(ctx context.Context, token string) (*VerifiedToken, error) {
+	verified, err := v.JWTVerifier.VerifyToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if emailVerified, _ := verified.Claims["email_verified"].(bool); !emailVerified || verified.Email == "" {
+		return nil, errors.New("service account token has no verified email")
+	}
+	serviceName, ok := v.ServiceAccounts[verified.Email]
+	if !ok || serviceName == "" {
+		return nil, errors.Errorf("service account %v is not allowed", verified.Email)
+	}
+	verified.Claims[ServiceClaim] = serviceName
+	return verified, nil
+}
+
+// NewServiceToken creates an HMAC signed service token for calling another service (for local / shared secret setups)
+// Usage: req.Header.Set("Authorization", "Bearer "+token); req.Header.Set("RequestContext", "Service")
+func NewServiceToken(secret []byte, serviceName string, audience string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":        serviceName,
+		ServiceClaim: serviceName,
+		"iat":        now.Unix(),
+		"exp":        now.Add(ttl).Unix(),
+	}
+	if audience != "" {
+		claims["aud"] = audience
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	return token, errors.WithStack(err)
+}
+
+// serviceNameFromToken returns the calling service from the "service" claim, empty if the token is not a service token
+func serviceNameFromToken(token *VerifiedToken) string {
+	serviceName, _ := token.Claims[ServiceClaim].(string)
+	return serviceName
+}
+
+func IsService(ctx *gin.Context) bool {
+	p, ok := PrincipalFrom(ctx)
+	return ok && p.IsService()
+}
+
+func GetServiceName(ctx *gin.Context) string {
+	if p, ok := PrincipalFrom(ctx); ok && p.IsService() {
+		return p.ServiceName
+	}
+	return ""
+}
+
+// IsAllowedService checks if the request was made by one of the given services ("*" for any service, no services are allowed when none are given)
+func IsAllowedService(ctx *gin.Context, services ...string) bool {
+	serviceName := GetServiceName(ctx)
+	if serviceName == "" {
+		return false
+	}
+	for _, service := range services {
+		if service == "*" || service == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireService : to verify only the given internal services ("*" for any service) access specific endpoint, denies all services when none are given
+func RequireService(services ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !IsAllowedService(ctx, services...) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. Service %q is not allowed.", GetServiceName(ctx))})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireAdminOrService : like RequireAdminAuth, but also allows the given internal services ("*" for any service)
+func RequireAdminOrService(services ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !GetIsAdmin(ctx) && !IsAllowedService(ctx, services...) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Authentication Error. No sufficient permissions.")})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package auth_test
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/auth/authtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serve(router *gin.Engine, token string, realm auth.RealmName, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, authtest.Authorize(httptest.NewRequest(http.MethodGet, path, nil), token, realm))
+	return recorder
+}
+
+func ok(ctx *gin.Context) {
+	ctx.Status(http.StatusOK)
+}
+
+func TestRequireServiceRejectsTokensWithoutServiceClaim(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := authtest.NewMinter()
+	router := gin.New()
+	router.GET("/internal", minter.Authenticator().AuthMiddleware, auth.RequireService("*"))
+
+	recorder := serve(router, minter.ConsumerToken(t, 7, false), auth.Service, "/internal")
+	authtest.AssertUnauthorized(t, recorder, auth.ServiceClaim)
+}
+
+func TestRequireServiceAllowsOnlyGivenServices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := authtest.NewMinter()
+	authenticator := minter.Authenticator()
+	router := gin.New()
+	router.GET("/orders", authenticator.AuthMiddleware, auth.RequireService("orders"), ok)
+	router.GET("/any", authenticator.AuthMiddleware, auth.RequireService("*"), ok)
+	router.GET("/none", authenticator.AuthMiddleware, auth.RequireService(), ok)
+
+	token := minter.ServiceToken(t, "orders")
+	authtest.AssertStatus(t, serve(router, token, auth.Service, "/orders"), http.StatusOK)
+	authtest.AssertStatus(t, serve(router, token, auth.Service, "/any"), http.StatusOK)
+	authtest.AssertForbidden(t, serve(router, token, auth.Service, "/none"))
+	authtest.AssertForbidden(t, serve(router, minter.ServiceToken(t, "payments"), auth.Service, "/orders"))
+}
+
+func TestGoogleOIDCServiceVerifier(t *testing.T) {
+	if _, err := auth.NewGoogleOIDCServiceVerifier("audience", nil); err == nil {
+		t.Fatal("expected the service account allowlist to be required")
+	}
+
+	secret := []byte("oidc-test-secret")
+	verifier := &auth.GoogleOIDCServiceVerifier{
+		JWTVerifier:     auth.NewHMACVerifier(secret, auth.GoogleOIDCIssuer, "audience"),
+		ServiceAccounts: map[string]string{"orders@project.iam.gserviceaccount.com": "orders"},
+	}
+	oidcToken := func(email string, emailVerified bool) string {
+		claims := jwt.MapClaims{"sub": "1234", "email": email, "email_verified": emailVerified, "iss": auth.GoogleOIDCIssuer, "aud": "audience", "exp": time.Now().Add(time.Hour).Unix()}
+		return signHS256(t, secret, claims)
+	}
+
+	token, err := verifier.VerifyToken(context.Background(), oidcToken("orders@project.iam.gserviceaccount.com", true))
+	if err != nil || token.Claims[auth.ServiceClaim] != "orders" {
+		t.Fatalf("expected the allowed service account to be verified as orders, got: %v, %v", token, err)
+	}
+	if _, err = verifier.VerifyToken(context.Background(), oidcToken("orders@project.iam.gserviceaccount.com", false)); err == nil {
+		t.Fatal("expected an unverified email to be rejected")
+	}
+	if _, err = verifier.VerifyToken(context.Background(), oidcToken("attacker@other.iam.gserviceaccount.com", true)); err == nil {
+		t.Fatal("expected a service account out of the allowlist to be rejected")
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+	"github.com/let-commerce/backend-common/env"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	"path/filepath"
+	"strings"
+)
+
+// FirebaseConfig configures a firebase project, credentials are only required when not using the auth emulator
+type FirebaseConfig struct {
+	ProjectID       string // required by the auth emulator, otherwise taken from the credentials
+	CredentialsFile string // service account key file path
+	CredentialsJSON []byte // inline service account key, takes precedence over CredentialsFile
+}
+
+// FirebaseConfigFromEnv creates a FirebaseConfig from the given env var, holding either the inline service account json or a key file path
+func FirebaseConfigFromEnv(credentialsEnvVar string) FirebaseConfig {
+	config := FirebaseConfig{ProjectID: env.GetEnvVar("FIREBASE_PROJECT_ID")}
+	credentials := strings.TrimSpace(env.GetEnvVar(credentialsEnvVar))
+	if strings.HasPrefix(credentials, "{") {
+		config.CredentialsJSON = []byte(credentials)
+	} else {
+		config.CredentialsFile = credentials
+	}
+	return config
+}
+
+// NewFirebaseClient creates a firebase auth client for the given config
+func NewFirebaseClient(ctx context.Context, config FirebaseConfig) (*auth.Client, error) {
+	var opt option.ClientOption
+	switch {
+	case len(config.CredentialsJSON) > 0:
+		opt = option.WithCredentialsJSON(config.CredentialsJSON)
+	case config.CredentialsFile != "":
+		serviceAccountKeyFilePath, err := filepath.Abs(config.CredentialsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load service account key file")
+		}
+		opt = option.WithCredentialsFile(serviceAccountKeyFilePath)
+	default:
+		return nil, errors.New("no firebase credentials configured")
+	}
+
+	var firebaseConfig *firebase.Config
+	if config.ProjectID != "" {
+		firebaseConfig = &firebase.Config{ProjectID: config.ProjectID}
+	}
+	app, err := firebase.NewApp(ctx, firebaseConfig, opt)
+	if err != nil {
+		return nil, errors.Wrap(err, "firebase admin SDK load error")
+	}
+	client, err := app.Auth(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "firebase auth load error")
+	}
+	return client, nil
+}
+
+// NewFirebaseTokenVerifier creates the TokenVerifier of the given config, verifying against the auth emulator when FIREBASE_AUTH_EMULATOR_HOST is set
+func NewFirebaseTokenVerifier(ctx context.Context, config FirebaseConfig) (TokenVerifier, error) {
+	if emulatorHost := env.GetEnvVar(EmulatorHostEnvVar); emulatorHost != "" {
+		return NewEmulatorVerifier(emulatorHost, config.ProjectID)
+	}
+	client, err := NewFirebaseClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewFirebaseVerifier(client), nil
+}
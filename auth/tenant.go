@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	TenantHeader = "X-Tenant-Id"
+	TenantIdKey  = "TENANT_ID"
+)
+
+// Tenant is a store / merchant hosted on the platform, migrated by the consumers service
+type Tenant struct {
+	ID   uint   `gorm:"primarykey"`
+	Name string `gorm:"not null"`
+	Host string `gorm:"uniqueIndex"` // the store host name (e.g. "shop.example.com"), used to resolve the tenant of a request
+}
+
+// BackOfficeUserTenant assigns a tenant to a backoffice user, limiting the user to the data of its assigned tenants
+type BackOfficeUserTenant struct {
+	BackOfficeUserID uint   `gorm:"primaryKey"`
+	TenantID         uint   `gorm:"primaryKey"`
+	Tenant           Tenant `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// TenantResolver resolves the tenant of a request host, zero means no tenant is hosted there
+type TenantResolver interface {
+	TenantByHost(ctx context.Context, host string) (uint, error)
+}
+
+func (r *SQLResolver) TenantByHost(ctx context.Context, host string) (uint, error) {
+	var tenantId uint
+	err := r.DB.WithContext(ctx).Raw("SELECT id FROM consumers.tenants WHERE host = ?", host).Scan(&tenantId).Error
+	return tenantId, errors.WithStack(err)
+}
+
+// ResolveTenant : to resolve the tenant of the request from the host, the X-Tenant-Id header or the single tenant assigned to the backoffice user.
+// A nil resolver defaults to an SQLResolver on the "DB" gin context key. Should run after RequireAuth on authenticated routes.
+// Usage: router.Use(authenticator.AuthMiddleware, authenticator.RequireAuth, auth.ResolveTenant(nil), auth.RequireTenantAccess)
+func ResolveTenant(resolver TenantResolver) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tenantId, err := hostTenant(ctx, resolver)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Authorization Error. Can't resolve tenant: %v.", err)})
+			ctx.Abort()
+			return
+		}
+
+		if headerValue := ctx.GetHeader(TenantHeader); headerValue != "" {
+			headerTenantId, err := strconv.ParseUint(headerValue, 10, 64)
+			if err != nil || headerTenantId == 0 || (tenantId != 0 && uint(headerTenantId) != tenantId) {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Authorization Error. Invalid %v header: %v.", TenantHeader, headerValue)})
+				ctx.Abort()
+				return
+			}
+			tenantId = uint(headerTenantId)
+		}
+
+		p, exists := PrincipalFrom(ctx)
+		if tenantId == 0 && exists && (p.IsBackofficeUser() || p.IsImpersonated()) && len(p.TenantIDs) == 1 {
+			tenantId = p.TenantIDs[0]
+		}
+		if tenantId != 0 {
+			ctx.Set(TenantIdKey, tenantId)
+			if exists {
+				withTenant := *p
+				withTenant.TenantID = tenantId
+				SetPrincipal(ctx, &withTenant)
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// RequireTenantAccess : to verify the request has a tenant, and the backoffice user (or the impersonating admin) is assigned to it (must run after ResolveTenant).
+// The tenants of backoffice users are loaded only by a resolver with tenancy enabled, e.g. authenticator.BackofficeUserResolver = &auth.SQLResolver{DB: db, Tenancy: true},
+// without it backoffice users (including admins) are denied every tenant.
+func RequireTenantAccess(ctx *gin.Context) {
+	tenantId := GetTenantId(ctx)
+	if tenantId == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Authorization Error. No tenant found for this request.")})
+		ctx.Abort()
+		return
+	}
+	if p, exists := PrincipalFrom(ctx); exists && !p.CanAccessTenant(tenantId) {
+		log.Warnf("Backoffice user %v denied access to tenant %v", p.BackofficeUserID, tenantId)
+		ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Authorization Error. No access to tenant %v.", tenantId)})
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+// GetTenantId returns the tenant resolved for the request, 0 if none
+func GetTenantId(ctx *gin.Context) uint {
+	if p, ok := PrincipalFrom(ctx); ok && p.TenantID != 0 {
+		return p.TenantID
+	}
+	return ctx.GetUint(TenantIdKey)
+}
+
+// TenantScope is a GORM scope filtering by the request tenant ("tenant_id" column), matching nothing when the request has no tenant
+// Usage: db.Scopes(auth.TenantScope(ctx)).Find(&orders)
+func TenantScope(ctx *gin.Context) func(db *gorm.DB) *gorm.DB {
+	tenantId := GetTenantId(ctx)
+	return func(db *gorm.DB) *gorm.DB {
+		if tenantId == 0 {
+			return db.Where("1 = 0")
+		}
+		return db.Where("tenant_id = ?", tenantId)
+	}
+}
+
+// TenantDB returns the request DB, filtered by the request tenant for every query made with it
+func TenantDB(ctx *gin.Context) *gorm.DB {
+	return ctx.MustGet("DB").(*gorm.DB).Scopes(TenantScope(ctx))
+}
+
+func hostTenant(ctx *gin.Context, resolver TenantResolver) (uint, error) {
+	host := ctx.Request.Host
+	if hostName, _, err := net.SplitHostPort(host); err == nil {
+		host = hostName
+	}
+	host = strings.ToLower(host)
+	if host == "" {
+		return 0, nil
+	}
+	if tenantId, ok := TenantHostCache.Get(host); ok {
+		return tenantId, nil
+	}
+	if resolver == nil {
+		resolver = NewSQLResolver(ctx.MustGet("DB").(*gorm.DB))
+	}
+	tenantId, err := resolver.TenantByHost(ctx, host)
+	if err != nil {
+		log.Errorf("Got error while resolving tenant of host %v: %v", host, err)
+		return 0, err
+	}
+	TenantHostCache.Set(host, tenantId)
+	return tenantId, nil
+}
+
+func loadBackofficeUserTenants(db *gorm.DB, backofficeUserId uint) ([]uint, error) {
+	var tenantIds []uint
+	err := db.Raw("SELECT tenant_id FROM consumers.back_office_user_tenants WHERE back_office_user_id = ?", backofficeUserId).Scan(&tenantIds).Error
+	return tenantIds, err
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"firebase.google.com/go/auth"
+	"github.com/pkg/errors"
+)
+
+// VerifiedToken is the issuer independent result of a successful token verification
+type VerifiedToken struct {
+	UID      string
+	Email    string
+	IssuedAt int64
+	Claims   map[string]interface{}
+}
+
+// RejectedTokenError is returned by verifiers for a token whose signature was verified, but which is expired or revoked (or of a disabled user).
+// Only these failures are counted per uid by the Lockout, the uid of any other failing token is not trusted.
+type RejectedTokenError struct {
+	UID string
+	Err error
+}
+
+func (e *RejectedTokenError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedTokenError) Unwrap() error {
+	return e.Err
+}
+
+// TokenVerifier verifies bearer tokens for one auth realm (consumers, backoffice, ...)
+type TokenVerifier interface {
+	// VerifyToken verifies the token signature and claims and returns the decoded token
+	VerifyToken(ctx context.Context, token string) (*VerifiedToken, error)
+	// GetUserEmail returns the email of the given user, used when the token carries no email claim (empty if the user has none)
+	GetUserEmail(ctx context.Context, uid string) (string, error)
+}
+
+// FirebaseClient is the subset of the firebase auth client used for verification (implemented by *auth.Client and *auth.TenantClient)
+type FirebaseClient interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+	GetUser(ctx context.Context, uid string) (*auth.UserRecord, error)
+}
+
+// FirebaseVerifier verifies Firebase ID tokens using the firebase admin SDK
+type FirebaseVerifier struct {
+	Client       FirebaseClient
+	CheckRevoked bool // opt-in check that the token was not revoked and the user is not disabled (results cached for RevocationTTL)
+}
+
+// NewFirebaseVerifier creates a TokenVerifier on top of the given firebase auth client
+func NewFirebaseVerifier(client FirebaseClient) *FirebaseVerifier {
+	return &FirebaseVerifier{Client: client}
+}
+
+// NewTenantVerifier creates a TokenVerifier for the given identity platform tenant of the firebase project
+func NewTenantVerifier(client *auth.Client, tenantId string) (*FirebaseVerifier, error) {
+	tenantClient, err := client.TenantManager.AuthForTenant(tenantId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get auth client of tenant %v", tenantId)
+	}
+	return NewFirebaseVerifier(tenantClient), nil
+}
+
+func (v *FirebaseVerifier) VerifyToken(ctx context.Context, token string) (*VerifiedToken, error) {
+	decoded, err := v.Client.VerifyIDToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if v.CheckRevoked {
+		if err = checkUserStatus(ctx, v.Client, decoded.UID, decoded.IssuedAt); err != nil {
+			return nil, err
+		}
+	}
+	email, _ := decoded.Claims["email"].(string)
+	return &VerifiedToken{UID: decoded.UID, Email: email, IssuedAt: decoded.IssuedAt, Claims: decoded.Claims}, nil
+}
+
+func (v *FirebaseVerifier) GetUserEmail(ctx context.Context, uid string) (string, error) {
+	userRecord, err := v.Client.GetUser(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+	if userRecord.UserInfo == nil {
+		return "", errors.Errorf("user %v has no user info", uid)
+	}
+	return userRecord.Email, nil
+}
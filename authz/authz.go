@@ -0,0 +1,172 @@
+// Package authz decides which principal may perform which action on which resource, by policies registered per resource type and action
+// Usage: if !authz.Authorize(ctx, authz.Update, &order) { return }
+package authz
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/response"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+type Action string
+
+const (
+	Create Action = "create"
+	Read   Action = "read"
+	Update Action = "update"
+	Delete Action = "delete"
+	Any    Action = "*" // registers a policy for all actions of a resource type
+)
+
+// Decision is the result of a single policy, the first policy not abstaining decides (guards can only deny)
+type Decision int
+
+const (
+	Abstain Decision = iota
+	Allow
+	Deny     // 403
+	NotFound // 404, denies without revealing the resource exists
+)
+
+// Policy decides (or abstains) on an action of the principal on the resource
+type Policy func(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision
+
+// Typed resources name their resource type, otherwise the type name is used (e.g. "Order" for Order and *Order)
+type Typed interface {
+	ResourceType() string
+}
+
+// Engine evaluates its guards, then the policies of the resource type and action, then its global policies, in registration order.
+// A registered policy can so restrict (or allow) a resource type before the global policies allow admins and owners.
+type Engine struct {
+	mutex    sync.RWMutex
+	guards   []Policy
+	global   []Policy
+	policies map[string]map[Action][]Policy
+}
+
+// NewEngine creates an Engine with the given global policies (evaluated for every resource type, after its registered policies)
+func NewEngine(globalPolicies ...Policy) *Engine {
+	return &Engine{global: globalPolicies, policies: map[string]map[Action][]Policy{}}
+}
+
+// DefaultEngine keeps tenant resources in their tenant and guests read only, then lets admins do anything and consumers access their own resources
+var DefaultEngine = NewEngine(AllowAdmins, AllowOwner).Guard(RequireTenantAccess, DenyGuests(Create, Update, Delete))
+
+// Guard adds guards, evaluated first for every resource type: a Deny / NotFound decides, an Allow is ignored (the next policies still decide)
+func (e *Engine) Guard(policies ...Policy) *Engine {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.guards = append(e.guards, policies...)
+	return e
+}
+
+// Use adds global policies, evaluated for every resource type after the registered policies and the existing global policies
+func (e *Engine) Use(policies ...Policy) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.global = append(e.global, policies...)
+}
+
+// Register adds policies for the given resource type and action (Any for all actions)
+func (e *Engine) Register(resourceType string, action Action, policies ...Policy) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.policies[resourceType] == nil {
+		e.policies[resourceType] = map[Action][]Policy{}
+	}
+	e.policies[resourceType][action] = append(e.policies[resourceType][action], policies...)
+}
+
+// Decide returns the decision on the action of the principal on the resource, Deny when no policy decides
+func (e *Engine) Decide(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+	e.mutex.RLock()
+	guards := e.guards
+	resourcePolicies := e.policies[ResourceType(resource)]
+	policies := make([]Policy, 0, len(resourcePolicies[Any])+len(resourcePolicies[action])+len(e.global))
+	policies = append(append(append(policies, resourcePolicies[Any]...), resourcePolicies[action]...), e.global...)
+	e.mutex.RUnlock()
+
+	for _, guard := range guards {
+		if decision := guard(ctx, p, action, resource); decision != Abstain && decision != Allow {
+			return decision
+		}
+	}
+	for _, policy := range policies {
+		if decision := policy(ctx, p, action, resource); decision != Abstain {
+			return decision
+		}
+	}
+	return Deny
+}
+
+// Authorize checks the authenticated principal may perform the action on the resource, otherwise writes a 401 / 403 / 404 and aborts
+func (e *Engine) Authorize(ctx *gin.Context, action Action, resource interface{}) bool {
+	resourceType := ResourceType(resource)
+	p, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		log.Warnf("Authorization denied: unauthenticated %v on %v", action, resourceType)
+		ctx.JSON(http.StatusUnauthorized, response.NewErrorMessageResponse("Unauthenticated"))
+		ctx.Abort()
+		return false
+	}
+
+	decision := e.Decide(ctx, p, action, resource)
+	switch decision {
+	case Allow:
+		log.Debugf("Authorization allowed: %v %v on %v", describe(p), action, resourceType)
+		return true
+	case NotFound:
+		log.Warnf("Authorization denied (not found): %v %v on %v", describe(p), action, resourceType)
+		ctx.JSON(http.StatusNotFound, response.NewErrorMessageResponse("Not Found"))
+	default:
+		log.Warnf("Authorization denied: %v %v on %v", describe(p), action, resourceType)
+		ctx.JSON(http.StatusForbidden, response.NewErrorMessageResponse("Forbidden"))
+	}
+	ctx.Abort()
+	return false
+}
+
+// Register adds policies for the given resource type and action to the DefaultEngine
+func Register(resourceType string, action Action, policies ...Policy) {
+	DefaultEngine.Register(resourceType, action, policies...)
+}
+
+// Authorize checks the action on the resource with the DefaultEngine
+func Authorize(ctx *gin.Context, action Action, resource interface{}) bool {
+	return DefaultEngine.Authorize(ctx, action, resource)
+}
+
+// ResourceType returns the type a resource's policies are registered by
+func ResourceType(resource interface{}) string {
+	if typed, ok := resource.(Typed); ok {
+		return typed.ResourceType()
+	}
+	resourceType := reflect.TypeOf(resource)
+	for resourceType != nil && resourceType.Kind() == reflect.Ptr {
+		resourceType = resourceType.Elem()
+	}
+	if resourceType == nil {
+		return ""
+	}
+	return resourceType.Name()
+}
+
+func describe(p *auth.Principal) string {
+	switch {
+	case p.IsService():
+		return "service " + p.ServiceName
+	case p.IsImpersonated():
+		return "consumer " + uintString(p.ConsumerID) + " (impersonated by backoffice user " + uintString(p.ImpersonatorID) + ")"
+	case p.IsBackofficeUser():
+		return "backoffice user " + uintString(p.BackofficeUserID)
+	case p.IsConsumer():
+		return "consumer " + uintString(p.ConsumerID)
+	default:
+		return "uid " + p.UID
+	}
+}
@@ -0,0 +1,68 @@
+package authz
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/auth/authtest"
+	"net/http/httptest"
+	"testing"
+)
+
+type order struct {
+	ConsumerID uint
+	TenantID   uint
+}
+
+func (o order) OwnerConsumerId() uint {
+	return o.ConsumerID
+}
+
+func (o order) OwnerTenantId() uint {
+	return o.TenantID
+}
+
+func deny(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+	return Deny
+}
+
+func testEngine() *Engine {
+	return NewEngine(AllowAdmins, AllowOwner).Guard(RequireTenantAccess, DenyGuests(Create, Update, Delete))
+}
+
+func decide(engine *Engine, p *auth.Principal, action Action, resource interface{}) Decision {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	return engine.Decide(ctx, p, action, resource)
+}
+
+func TestRegisteredPoliciesRunBeforeGlobalPolicies(t *testing.T) {
+	engine := testEngine()
+	engine.Register("order", Delete, deny)
+
+	resource := order{ConsumerID: 7, TenantID: 1}
+	if decision := decide(engine, authtest.Consumer(7), Delete, resource); decision != Deny {
+		t.Fatalf("expected the registered policy to deny the owner, got %v", decision)
+	}
+	if decision := decide(engine, authtest.Admin(3), Delete, resource); decision != Deny {
+		t.Fatalf("expected the registered policy to deny the admin, got %v", decision)
+	}
+	if decision := decide(engine, authtest.Consumer(7), Read, resource); decision != Allow {
+		t.Fatalf("expected the owner to read, got %v", decision)
+	}
+}
+
+func TestGuardsRunFirst(t *testing.T) {
+	engine := testEngine()
+	engine.Register("order", Any, AllowPermission("orders.manage"))
+
+	tenantAdmin := authtest.Admin(3)
+	tenantAdmin.TenantIDs, tenantAdmin.AllTenants = []uint{2}, false
+	if decision := decide(engine, tenantAdmin, Read, order{TenantID: 1}); decision != NotFound {
+		t.Fatalf("expected an admin of another tenant to get NotFound, got %v", decision)
+	}
+	if decision := decide(engine, authtest.Guest(7), Update, order{ConsumerID: 7, TenantID: 1}); decision != Deny {
+		t.Fatalf("expected a guest owner to be denied updates, got %v", decision)
+	}
+	if decision := decide(engine, authtest.Consumer(8), Read, order{ConsumerID: 7, TenantID: 1}); decision != NotFound {
+		t.Fatalf("expected another consumer to get NotFound, got %v", decision)
+	}
+}
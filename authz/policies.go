@@ -0,0 +1,97 @@
+package authz
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth"
+	"strconv"
+)
+
+// Owned resources belong to a consumer
+type Owned interface {
+	OwnerConsumerId() uint
+}
+
+// TenantOwned resources belong to a tenant (store / merchant)
+type TenantOwned interface {
+	OwnerTenantId() uint
+}
+
+// AllowAdmins allows admin backoffice users any action
+func AllowAdmins(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+	if p.IsAdmin {
+		return Allow
+	}
+	return Abstain
+}
+
+// AllowOwner allows consumers any action on their own resources, other consumers get NotFound
+func AllowOwner(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+	owned, ok := resource.(Owned)
+	if !ok || !p.IsConsumer() {
+		return Abstain
+	}
+	if owned.OwnerConsumerId() == p.ConsumerID {
+		return Allow
+	}
+	return NotFound
+}
+
+// DenyGuests denies guest consumers the given actions (all actions when none given)
+func DenyGuests(actions ...Action) Policy {
+	return func(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+		if !p.IsConsumer() || !p.IsGuest || !containsAction(actions, action) {
+			return Abstain
+		}
+		return Deny
+	}
+}
+
+// AllowServices allows the given internal services any action ("*" for all services)
+func AllowServices(services ...string) Policy {
+	return func(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+		if !p.IsService() {
+			return Abstain
+		}
+		for _, service := range services {
+			if service == "*" || service == p.ServiceName {
+				return Allow
+			}
+		}
+		return Abstain
+	}
+}
+
+// AllowPermission allows backoffice users with the given permission
+func AllowPermission(permission string) Policy {
+	return func(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+		if p.IsBackofficeUser() && auth.HasPermission(ctx, permission) {
+			return Allow
+		}
+		return Abstain
+	}
+}
+
+// RequireTenantAccess hides tenant resources from principals with no access to the tenant, a guard (see Engine.Guard)
+func RequireTenantAccess(ctx *gin.Context, p *auth.Principal, action Action, resource interface{}) Decision {
+	owned, ok := resource.(TenantOwned)
+	if !ok || p.CanAccessTenant(owned.OwnerTenantId()) {
+		return Abstain
+	}
+	return NotFound
+}
+
+func containsAction(actions []Action, action Action) bool {
+	if len(actions) == 0 {
+		return true
+	}
+	for _, a := range actions {
+		if a == action || a == Any {
+			return true
+		}
+	}
+	return false
+}
+
+func uintString(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,312 @@
+package ginutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/let-commerce/backend-common/response"
+	"github.com/let-commerce/backend-common/utils/datetime"
+	"github.com/let-commerce/backend-common/utils/encoders"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request field sources, the struct tags read by BindRequest
+const (
+	PathSource   = "path"
+	QuerySource  = "query"
+	HeaderSource = "header"
+	BodySource   = "body"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// BindRequest method binds new T from the request JSON body, path params, query and headers, and return a single http.StatusBadRequest with all the invalid fields.
+// Fields are bound by the tags `path:"id"`, `query:"from"` and `header:"X-Store"` (never from the body), with the options:
+// ",required" (a "null" query counts as missing, like in GetIntQuery) and ",hashid" (an id encoded by encoders.EncodeId).
+// Dates are parsed like datetime.ParseDate, or by a `format:"2006-01-02"` tag, slices take repeated or comma separated values, and `binding` tags are validated.
+// Usage:
+//
+//	type GetOrdersRequest struct {
+//		StoreID  uint      `path:"storeId,hashid" json:"-"`
+//		From     time.Time `query:"from,required" json:"-"`
+//		Statuses []string  `query:"status" json:"-"`
+//		Store    string    `header:"X-Store" json:"-"`
+//	}
+func BindRequest[T any](ctx *gin.Context) (T, error) {
+	req, fields, _ := bindRequest[T](ctx)
+	if len(fields) > 0 {
+		var null T
+		ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while binding request", fields))
+		return null, FieldErrors(fields)
+	}
+	return req, nil
+}
+
+// bindRequest binds T like BindRequest without writing the response, decoded reports whether the body was decoded (so IValidatable.Validate can run on it)
+func bindRequest[T any](ctx *gin.Context) (T, []response.FieldError, bool) {
+	var req T
+	var fields []response.FieldError
+	decoded := true
+	if ctx.Request.Body != nil {
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil && err != io.EOF {
+			fields = append(fields, bodyFieldError(err))
+			decoded = false
+		}
+	}
+
+	value := reflect.ValueOf(&req).Elem()
+	if value.Kind() == reflect.Struct {
+		fields = append(fields, bindFields(ctx, value)...)
+		if err := binding.Validator.ValidateStruct(&req); err != nil {
+			fields = appendFieldErrors(fields, validationFieldErrors(value.Type(), err)...)
+		}
+	}
+	return req, fields, decoded
+}
+
+// bindFields binds the path, query and header tagged fields of the struct (and its embedded structs), resetting what the body may have set in them
+func bindFields(ctx *gin.Context, value reflect.Value) []response.FieldError {
+	var fields []response.FieldError
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		source, tag := fieldSource(field)
+		if source == BodySource {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				fields = append(fields, bindFields(ctx, value.Field(i))...)
+			}
+			continue
+		}
+
+		value.Field(i).Set(reflect.Zero(field.Type)) // only its own source can set the field
+		name, options, _ := strings.Cut(tag, ",")
+		values := requestValues(ctx, source, name)
+		if len(values) == 0 {
+			if strings.Contains(options, "required") {
+				fields = append(fields, response.FieldError{Field: name, Rule: "required", Source: source, Message: "is required"})
+			}
+			continue
+		}
+		hashid := strings.Contains(options, "hashid")
+		if err := setField(value.Field(i), values, hashid, field.Tag.Get("format")); err != nil {
+			fields = append(fields, response.FieldError{Field: name, Rule: "type", Source: source, Message: err.Error()})
+		}
+	}
+	return fields
+}
+
+// fieldSource returns the source of the field and its tag, fields without a path, query or header tag are bound from the body
+func fieldSource(field reflect.StructField) (string, string) {
+	for _, source := range []string{PathSource, QuerySource, HeaderSource} {
+		if tag, ok := field.Tag.Lookup(source); ok {
+			return source, tag
+		}
+	}
+	return BodySource, ""
+}
+
+// requestValues returns the non empty values of the request field ("null" query values are ignored)
+func requestValues(ctx *gin.Context, source string, name string) []string {
+	var values []string
+	switch source {
+	case PathSource:
+		values = []string{ctx.Params.ByName(name)}
+	case QuerySource:
+		values = ctx.QueryArray(name)
+	case HeaderSource:
+		values = ctx.Request.Header.Values(name)
+	}
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == "" || (source == QuerySource && value == "null") {
+			continue
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+func setField(field reflect.Value, values []string, hashid bool, format string) error {
+	switch {
+	case field.Kind() == reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), values, hashid, format); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	case field.Kind() == reflect.Slice:
+		var items []string
+		for _, value := range values {
+			items = append(items, strings.Split(value, ",")...)
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), strings.TrimSpace(item), hashid, format); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	default:
+		return setValue(field, values[0], hashid, format)
+	}
+}
+
+func setValue(field reflect.Value, value string, hashid bool, format string) error {
+	if field.Type() == timeType {
+		var date time.Time
+		var err error
+		if format == "" {
+			date, err = datetime.ParseDate(value)
+		} else {
+			date, err = time.Parse(format, value)
+		}
+		if err != nil {
+			return fmt.Errorf("can't parse %q as a date", value)
+		}
+		field.Set(reflect.ValueOf(date))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		result, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("can't parse %q as a bool", value)
+		}
+		field.SetBool(result)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		result, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("can't parse %q as an int", value)
+		}
+		field.SetInt(result)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if hashid {
+			id, err := encoders.DecodeIdWithError(value)
+			if err != nil {
+				return fmt.Errorf("can't decode %q as an id", value)
+			}
+			field.SetUint(uint64(id))
+			return nil
+		}
+		result, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("can't parse %q as an uint", value)
+		}
+		field.SetUint(result)
+	case reflect.Float32, reflect.Float64:
+		result, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("can't parse %q as a number", value)
+		}
+		field.SetFloat(result)
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Type())
+	}
+	return nil
+}
+
+// appendFieldErrors appends the errors of the fields which have no error yet
+func appendFieldErrors(fields []response.FieldError, fieldErrors ...response.FieldError) []response.FieldError {
+	for _, fieldErr := range fieldErrors {
+		exists := false
+		for _, field := range fields {
+			exists = exists || (field.Source == fieldErr.Source && field.Field == fieldErr.Field)
+		}
+		if !exists {
+			fields = append(fields, fieldErr)
+		}
+	}
+	return fields
+}
+
+func bodyFieldError(err error) response.FieldError {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+		return response.FieldError{Field: typeErr.Field, Rule: "type", Source: BodySource, Message: fmt.Sprintf("can't bind %v to %v", typeErr.Value, typeErr.Type)}
+	}
+	return response.FieldError{Field: "", Rule: "parse", Source: BodySource, Message: err.Error()}
+}
+
+// validationFieldErrors maps the validator errors to field errors, named by their tag (or JSON) names
+func validationFieldErrors(structType reflect.Type, err error) []response.FieldError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return []response.FieldError{{Rule: "validation", Source: BodySource, Message: err.Error()}}
+	}
+	fields := make([]response.FieldError, len(validationErrors))
+	for i, fieldErr := range validationErrors {
+		source, name := fieldPath(structType, fieldErr.StructNamespace())
+		fields[i] = response.FieldError{Field: name, Rule: fieldErr.Tag(), Source: source, Message: validationMessage(fieldErr)}
+	}
+	return fields
+}
+
+// fieldPath resolves the validator struct namespace (e.g. "Request.Items[0].Name") to the source and the request name of the field (e.g. "items[0].name")
+func fieldPath(structType reflect.Type, namespace string) (string, string) {
+	parts := strings.Split(namespace, ".")[1:]
+	source := BodySource
+	names := make([]string, 0, len(parts))
+	currentType := structType
+	for i, part := range parts {
+		fieldName, index, _ := strings.Cut(part, "[")
+		if index != "" {
+			index = "[" + index
+		}
+		for currentType.Kind() == reflect.Pointer || currentType.Kind() == reflect.Slice || currentType.Kind() == reflect.Map {
+			currentType = currentType.Elem()
+		}
+		field, ok := reflect.StructField{}, false
+		if currentType.Kind() == reflect.Struct {
+			field, ok = currentType.FieldByName(fieldName)
+		}
+		if !ok {
+			names = append(names, part)
+			continue
+		}
+		name := fieldName
+		if fieldSource, tag := fieldSource(field); fieldSource != BodySource {
+			if i == 0 {
+				source = fieldSource
+			}
+			name, _, _ = strings.Cut(tag, ",")
+		} else if jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ","); jsonName != "" && jsonName != "-" {
+			name = jsonName
+		}
+		names = append(names, name+index)
+		currentType = field.Type
+	}
+	return source, strings.Join(names, ".")
+}
+
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return fmt.Sprintf("must be at least %v", fieldErr.Param())
+	case "max", "lte":
+		return fmt.Sprintf("must be at most %v", fieldErr.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %v", fieldErr.Param())
+	case "email":
+		return "must be a valid email"
+	}
+	if fieldErr.Param() != "" {
+		return fmt.Sprintf("failed the %v=%v validation", fieldErr.Tag(), fieldErr.Param())
+	}
+	return fmt.Sprintf("failed the %v validation", fieldErr.Tag())
+}
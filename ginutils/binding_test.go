@@ -0,0 +1,85 @@
+package ginutils
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/response"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindTestRequest struct {
+	OrderID  uint      `path:"orderId"`
+	From     time.Time `query:"from,required"`
+	Statuses []string  `query:"status"`
+	Store    string    `header:"X-Store"`
+	Note     string    `json:"note" binding:"max=5"`
+}
+
+func (r bindTestRequest) Validate() error {
+	var errs FieldErrors
+	if r.Note == "oops" {
+		errs.Add("note", "oops", "must not be oops")
+	}
+	return errs.Err()
+}
+
+func bindTestContext(method string, target string, body string, headers map[string]string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	for key, value := range headers {
+		ctx.Request.Header.Set(key, value)
+	}
+	ctx.Params = gin.Params{{Key: "orderId", Value: "12"}}
+	return ctx, recorder
+}
+
+func errorFields(t *testing.T, recorder *httptest.ResponseRecorder) []response.FieldError {
+	t.Helper()
+	var body response.ErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected an error response, got: %v", recorder.Body.String())
+	}
+	return body.Errors
+}
+
+func TestBindRequestFieldSources(t *testing.T) {
+	ctx, _ := bindTestContext(http.MethodPost, "/orders/12?from=3/1/2022&status=paid,sent&status=new", `{"note":"hi"}`, map[string]string{"X-Store": "main"})
+	req, err := BindRequest[bindTestRequest](ctx)
+	if err != nil {
+		t.Fatalf("expected the request to bind, got: %v", err)
+	}
+	if req.OrderID != 12 || req.From.Format("2006-01-02") != "2022-03-01" || strings.Join(req.Statuses, "|") != "paid|sent|new" || req.Store != "main" || req.Note != "hi" {
+		t.Fatalf("unexpected bound request: %+v", req)
+	}
+}
+
+func TestBindRequestIgnoresSourceFieldsInBody(t *testing.T) {
+	ctx, _ := bindTestContext(http.MethodPost, "/orders/12?from=3/1/2022", `{"Store":"evil","OrderID":99}`, nil)
+	req, err := BindRequest[bindTestRequest](ctx)
+	if err != nil {
+		t.Fatalf("expected the request to bind, got: %v", err)
+	}
+	if req.Store != "" || req.OrderID != 12 {
+		t.Fatalf("expected the body not to set the header and path fields, got: %+v", req)
+	}
+}
+
+func TestBindRequestReportsAllFields(t *testing.T) {
+	ctx, recorder := bindTestContext(http.MethodPost, "/orders/12?status=paid", `{"note":"too long"}`, nil)
+	if _, err := BindRequest[bindTestRequest](ctx); err == nil {
+		t.Fatal("expected the request not to bind")
+	}
+	fields := errorFields(t, recorder)
+	if recorder.Code != http.StatusBadRequest || len(fields) != 2 {
+		t.Fatalf("expected a 400 with the from and note fields, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	if fields[0].Field != "from" || fields[0].Source != QuerySource || fields[1].Field != "note" || fields[1].Source != BodySource {
+		t.Fatalf("unexpected field errors: %+v", fields)
+	}
+}
@@ -0,0 +1,280 @@
+package ginutils
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/response"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type FilterOperator string
+
+const (
+	Eq      FilterOperator = "eq"
+	In      FilterOperator = "in"
+	Gte     FilterOperator = "gte"
+	Like    FilterOperator = "like"
+	Between FilterOperator = "between"
+)
+
+var filterParamRegex = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[([^\[\]]+)\])?$`)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// FilterSpec holds the filterable and sortable fields of a list endpoint, built by NewFilterSpec from the tags of a DTO
+type FilterSpec struct {
+	filters     map[string]filterField
+	sorts       map[string]string // sort name -> column
+	defaultSort string
+}
+
+type filterField struct {
+	column    string
+	operators []FilterOperator
+	valueType reflect.Type
+	hashid    bool
+	format    string
+}
+
+// Filter is a single parsed filter of a list request
+type Filter struct {
+	Column   string
+	Operator FilterOperator
+	Values   []interface{}
+}
+
+// Sort is a single parsed sort of a list request
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
+// FilterQuery is the filters and sorts of a list request, bound by GetFilterQuery
+type FilterQuery struct {
+	Filters     []Filter
+	Sorts       []Sort
+	defaultSort bool // the sorts are the spec default sort, replaced by the cursor order in cursor pagination
+}
+
+// defaultSortKey marks a query ordered only by the default sort of a FilterQuery, see PageRequest.Scope
+const defaultSortKey = "ginutils:default_sort"
+
+// NewFilterSpec method builds the FilterSpec of T from its tags, and panics on invalid tags:
+// `filter:"status,eq,in"` (the filter name and its allowed operators, eq and in when omitted, options: hashid), `sort:"created_at"` (the sort name),
+// `column:"orders.status"` (the DB column, the filter / sort name when omitted) and `format:"2006-01-02"` (the date format, like in BindRequest).
+// The default sort (e.g. "-created_at") is used when the request has no sort.
+// Usage:
+//
+//	type OrderFilter struct {
+//		Status    string    `filter:"status,eq,in"`
+//		Total     float64   `filter:"total,gte,between" sort:"total"`
+//		CreatedAt time.Time `filter:"created_at,gte,between" sort:"created_at"`
+//	}
+//	var orderFilterSpec = ginutils.NewFilterSpec[OrderFilter]("-created_at")
+func NewFilterSpec[T any](defaultSort string) FilterSpec {
+	spec := FilterSpec{filters: map[string]filterField{}, sorts: map[string]string{}, defaultSort: defaultSort}
+	var dto T
+	dtoType := reflect.TypeOf(dto)
+	if dtoType == nil || dtoType.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("filter spec must be a struct, got %v", dtoType))
+	}
+	for i := 0; i < dtoType.NumField(); i++ {
+		field := dtoType.Field(i)
+		column := field.Tag.Get("column")
+		if tag, ok := field.Tag.Lookup("filter"); ok {
+			parts := strings.Split(tag, ",")
+			filter := filterField{column: column, valueType: field.Type, format: field.Tag.Get("format")}
+			if filter.column == "" {
+				filter.column = parts[0]
+			}
+			for filter.valueType.Kind() == reflect.Pointer || filter.valueType.Kind() == reflect.Slice {
+				filter.valueType = filter.valueType.Elem()
+			}
+			for _, option := range parts[1:] {
+				switch operator := FilterOperator(option); operator {
+				case Eq, In, Gte, Like, Between:
+					filter.operators = append(filter.operators, operator)
+				case "hashid":
+					filter.hashid = true
+				default:
+					panic(fmt.Sprintf("unknown filter operator %v of field %v", option, field.Name))
+				}
+			}
+			if len(filter.operators) == 0 {
+				filter.operators = []FilterOperator{Eq, In}
+			}
+			spec.filters[parts[0]] = filter
+		}
+		if name, ok := field.Tag.Lookup("sort"); ok {
+			spec.sorts[name] = column
+			if column == "" {
+				spec.sorts[name] = name
+			}
+		}
+	}
+	return spec
+}
+
+// GetFilterQuery method binds the "filter[name]" (or "filter[name][operator]") and "sort" query params from ctx, and return http.StatusBadRequest with all the invalid params.
+// Comma separated values filter with "in" (and "between"), a "-" sort prefix sorts descending, e.g. "?sort=-created_at,id&filter[status]=paid,shipped&filter[total][gte]=100"
+// Usage:
+//
+//	filterQuery, err := ginutils.GetFilterQuery(ctx, orderFilterSpec)
+//	if err != nil {
+//		return
+//	}
+//	db.Scopes(filterQuery.Scope, pageRequest.Scope).Find(&orders)
+func GetFilterQuery(ctx *gin.Context, spec FilterSpec) (FilterQuery, error) {
+	var q FilterQuery
+	var fields []response.FieldError
+	query := ctx.Request.URL.Query()
+	params := make([]string, 0, len(query))
+	for param := range query {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		if !strings.HasPrefix(param, "filter[") {
+			continue
+		}
+		filter, err := spec.parseFilter(param, query[param])
+		if err != nil {
+			fields = append(fields, response.FieldError{Field: param, Rule: "filter", Source: QuerySource, Message: err.Error()})
+		} else if filter != nil {
+			q.Filters = append(q.Filters, *filter)
+		}
+	}
+
+	sortParam, exists := GetStringQuery(ctx, "sort")
+	if !exists || sortParam == "" {
+		sortParam, q.defaultSort = spec.defaultSort, spec.defaultSort != ""
+	}
+	for _, name := range strings.Split(sortParam, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := strings.HasPrefix(name, "-")
+		column, ok := spec.sorts[strings.TrimPrefix(name, "-")]
+		if !ok {
+			fields = append(fields, response.FieldError{Field: "sort", Rule: "sort", Source: QuerySource, Message: fmt.Sprintf("can't sort by %v (allowed: %v)", name, strings.Join(sortedKeys(spec.sorts), ", "))})
+			continue
+		}
+		q.Sorts = append(q.Sorts, Sort{Column: column, Desc: desc})
+	}
+
+	if len(fields) > 0 {
+		ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while binding filters", fields))
+		return FilterQuery{}, FieldErrors(fields)
+	}
+	return q, nil
+}
+
+// parseFilter parses a filter query param, returns nil for a "null" filter (like the other query params)
+func (spec FilterSpec) parseFilter(param string, paramValues []string) (*Filter, error) {
+	match := filterParamRegex.FindStringSubmatch(param)
+	if match == nil {
+		return nil, fmt.Errorf("invalid filter, expected filter[name] or filter[name][operator]")
+	}
+	field, ok := spec.filters[match[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %v (allowed: %v)", match[1], strings.Join(sortedKeys(spec.filters), ", "))
+	}
+
+	var rawValues []string
+	for _, value := range paramValues {
+		if value == "null" {
+			continue
+		}
+		rawValues = append(rawValues, strings.Split(value, ",")...)
+	}
+	if len(rawValues) == 0 {
+		return nil, nil
+	}
+
+	operator := FilterOperator(match[2])
+	if operator == "" {
+		operator = Eq
+		if len(rawValues) > 1 {
+			operator = In
+		}
+	}
+	allowed := false
+	for _, fieldOperator := range field.operators {
+		allowed = allowed || fieldOperator == operator
+	}
+	if !allowed {
+		return nil, fmt.Errorf("operator %v is not allowed (allowed: %v)", operator, joinOperators(field.operators))
+	}
+	if operator == Between && len(rawValues) != 2 {
+		return nil, fmt.Errorf("between expects 2 comma separated values")
+	}
+	if operator != In && operator != Between && len(rawValues) != 1 {
+		return nil, fmt.Errorf("%v expects a single value", operator)
+	}
+
+	filter := &Filter{Column: field.column, Operator: operator}
+	for _, rawValue := range rawValues {
+		if operator == Like {
+			filter.Values = append(filter.Values, "%"+likeEscaper.Replace(rawValue)+"%")
+			continue
+		}
+		value := reflect.New(field.valueType).Elem()
+		if err := setValue(value, strings.TrimSpace(rawValue), field.hashid, field.format); err != nil {
+			return nil, err
+		}
+		filter.Values = append(filter.Values, value.Interface())
+	}
+	return filter, nil
+}
+
+// Scope is a GORM scope applying the filters and sorts to the query, the columns are quoted so they are safe from injections
+// Usage: db.Scopes(filterQuery.Scope).Find(&orders)
+func (q FilterQuery) Scope(db *gorm.DB) *gorm.DB {
+	for _, filter := range q.Filters {
+		column := clause.Column{Name: filter.Column}
+		switch filter.Operator {
+		case Eq:
+			db = db.Where(clause.Eq{Column: column, Value: filter.Values[0]})
+		case In:
+			db = db.Where(clause.IN{Column: column, Values: filter.Values})
+		case Gte:
+			db = db.Where(clause.Gte{Column: column, Value: filter.Values[0]})
+		case Like:
+			db = db.Where(clause.Like{Column: column, Value: filter.Values[0]})
+		case Between:
+			db = db.Where(clause.Gte{Column: column, Value: filter.Values[0]}).Where(clause.Lte{Column: column, Value: filter.Values[1]})
+		}
+	}
+	if _, ordered := db.Statement.Clauses["ORDER BY"]; q.defaultSort && !ordered {
+		db = db.InstanceSet(defaultSortKey, true)
+	}
+	for _, s := range q.Sorts {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: s.Column}, Desc: s.Desc})
+	}
+	return db
+}
+
+func joinOperators(operators []FilterOperator) string {
+	names := make([]string, len(operators))
+	for i, operator := range operators {
+		names[i] = string(operator)
+	}
+	return strings.Join(names, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,6 +3,7 @@ package ginutils
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 	"github.com/jinzhu/copier"
 	"github.com/let-commerce/backend-common/auth"
 	"github.com/let-commerce/backend-common/response"
@@ -10,23 +11,47 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"reflect"
 	"strconv"
 	"time"
 )
 
-// ValidateAuthorized method validates the given consumer id matches the authenticated one, or he is admin
+// AuthorizeOptions controls who besides the consumer himself (and admins) may pass ValidateAuthorizedWithOptions
+type AuthorizeOptions struct {
+	AllowGuests   bool
+	AllowServices []string // internal services allowed to act on behalf of any consumer, "*" for all services
+}
+
+// ValidateAuthorized method validates the given consumer id matches the authenticated one, or he is admin (services are denied)
+// For resources with ownership rules, prefer authz.Authorize (proper 403 / 404 responses, aborts the context)
 func ValidateAuthorized(ctx *gin.Context, consumerId uint, allowGuests bool) bool {
+	return ValidateAuthorizedWithOptions(ctx, consumerId, AuthorizeOptions{AllowGuests: allowGuests})
+}
+
+// ValidateAuthorizedWithOptions method validates the given consumer id matches the authenticated one, or he is admin, or an allowed service
+func ValidateAuthorizedWithOptions(ctx *gin.Context, consumerId uint, options AuthorizeOptions) bool {
 	authenticatedConsumerId := auth.GetAuthenticatedConsumerId(ctx)
 	isAdmin := auth.GetIsAdmin(ctx)
 	if isAdmin {
 		return true
 	}
+	if serviceName := auth.GetServiceName(ctx); serviceName != "" {
+		for _, service := range options.AllowServices {
+			if service == "*" || service == serviceName {
+				return true
+			}
+		}
+		log.Errorf("got unauthorized service operation! consumer id: %v service: %v", consumerId, serviceName)
+		ctx.JSON(http.StatusUnauthorized, response.NewErrorMessageResponse("Unauthorized"))
+		return false
+	}
 	if authenticatedConsumerId == 0 || consumerId != authenticatedConsumerId {
 		log.Errorf("got unauthenticated consumer id! consumer id: %v authenticatedConsumerId: %v isAdmin: %v", consumerId, authenticatedConsumerId, isAdmin)
+		auth.EmitAuthEvent(ctx, auth.UnauthenticatedAccessEvent, fmt.Sprintf("consumer id: %v", consumerId))
 		ctx.JSON(http.StatusUnauthorized, response.NewErrorMessageResponse("Unauthenticated"))
 		return false
 	}
-	if !allowGuests && auth.GetIsGuest(ctx) {
+	if !options.AllowGuests && auth.GetIsGuest(ctx) {
 		log.Errorf("unauthorized guest operation! consumer id: %v authenticatedConsumerId: %v isAdmin: %v", consumerId, authenticatedConsumerId, isAdmin)
 		ctx.JSON(http.StatusUnauthorized, response.NewErrorMessageResponse("Unauthorized"))
 		return false
@@ -122,43 +147,57 @@ func GetBoolQuery(ctx *gin.Context, paramName string) (bool, bool, error) {
 	return false, exists, nil
 }
 
-// BindDTO method binds new DTO from ctx body
+// BindDTO method binds new DTO from ctx body and return http.StatusBadRequest with the invalid fields (of the body and the `binding` tags) if it couldn't bind
 func BindDTO[T any](ctx *gin.Context, dto T) (T, error) {
-	err := ctx.Bind(&dto)
+	err := ctx.ShouldBind(&dto)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, response.NewErrorResponse(fmt.Sprintf("Got error while binding dto: %v", dto), errors.WithStack(err)))
+		fields := bindFieldErrors(reflect.TypeOf(dto), err)
+		ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while binding dto", fields))
+		return dto, FieldErrors(fields)
 	}
-	return dto, err
+	return dto, nil
 }
 
-// BindMap method binds new map from ctx body
+// BindMap method binds new map from ctx body and return http.StatusBadRequest if it couldn't bind
 func BindMap(ctx *gin.Context) (map[string]interface{}, error) {
 	var dto map[string]interface{}
-	err := ctx.Bind(&dto)
+	err := ctx.ShouldBind(&dto)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, response.NewErrorResponse("Got error while binding map", errors.WithStack(err)))
+		fields := []response.FieldError{bodyFieldError(err)}
+		ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while binding map", fields))
+		return dto, FieldErrors(fields)
 	}
-	return dto, err
+	return dto, nil
 }
 
 type IValidatable interface {
-	Validate() error
+	Validate() error // may return FieldErrors, to report several invalid fields
 }
 
-// BindAndValidateDTO method binds new DTO from ctx body
+// BindAndValidateDTO method binds new DTO from ctx body, validates it (by the `binding` tags and Validate) and return http.StatusBadRequest with all the invalid fields
 func BindAndValidateDTO[T IValidatable](ctx *gin.Context, dto T) (T, error) {
 	var null T
-	err := ctx.Bind(&dto)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, response.NewErrorResponse("Got error while binding dto", errors.WithStack(err)))
-		return null, err
+	err := ctx.ShouldBind(&dto)
+	var validationErrors validator.ValidationErrors
+	if err != nil && !errors.As(err, &validationErrors) {
+		fields := bindFieldErrors(reflect.TypeOf(dto), err)
+		ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while binding dto", fields))
+		return null, FieldErrors(fields)
 	}
-	err = dto.Validate()
+
+	// the body was decoded, so Validate runs even if some `binding` tags failed, to report all the invalid fields at once
+	var fields []response.FieldError
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, response.NewErrorResponse("Got error while validating dto", errors.WithStack(err)))
-		return null, err
+		fields = bindFieldErrors(reflect.TypeOf(dto), err)
+	}
+	if validateErr := dto.Validate(); validateErr != nil {
+		fields = appendFieldErrors(fields, validateFieldErrors(validateErr)...)
+	}
+	if len(fields) > 0 {
+		ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while validating dto", fields))
+		return null, FieldErrors(fields)
 	}
-	return dto, err
+	return dto, nil
 }
 
 func ReturnResultOrError(ctx *gin.Context, result interface{}, errMessage string, err error) {
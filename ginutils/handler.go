@@ -0,0 +1,178 @@
+package ginutils
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/response"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"reflect"
+)
+
+// Errors mapped to their status codes by Handle, wrap them (or return a StatusError) to answer with a client error
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+)
+
+var errorStatuses = []errorStatus{
+	{ErrBadRequest, http.StatusBadRequest},
+	{ErrUnauthorized, http.StatusUnauthorized},
+	{ErrForbidden, http.StatusForbidden},
+	{ErrNotFound, http.StatusNotFound},
+	{ErrConflict, http.StatusConflict},
+	{gorm.ErrRecordNotFound, http.StatusNotFound},
+}
+
+type errorStatus struct {
+	err    error
+	status int
+}
+
+// StatusError is an error answered by Handle with the given status and message
+type StatusError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// NewStatusError creates a StatusError, err may be nil
+func NewStatusError(status int, message string, err error) error {
+	return &StatusError{Status: status, Message: message, Err: err}
+}
+
+// RegisterErrorStatus maps the errors matching target (by errors.Is) to the given status in Handle, e.g. a service "out of stock" error to http.StatusConflict
+func RegisterErrorStatus(target error, status int) {
+	errorStatuses = append(errorStatuses, errorStatus{err: target, status: status})
+}
+
+// ErrorStatus returns the status Handle answers the error with, http.StatusInternalServerError for unknown errors
+func ErrorStatus(err error) int {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status
+	}
+	var fieldErrors FieldErrors
+	if errors.As(err, &fieldErrors) {
+		return http.StatusBadRequest
+	}
+	for i := len(errorStatuses) - 1; i >= 0; i-- {
+		if errors.Is(err, errorStatuses[i].err) {
+			return errorStatuses[i].status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// NoContent is an empty response, answered with http.StatusNoContent
+type NoContent struct{}
+
+type ginContextKey struct{}
+
+// GinContext returns the gin.Context of a Handle handler ctx, e.g. for authz.Authorize or FindPage
+func GinContext(ctx context.Context) (*gin.Context, bool) {
+	ginCtx, ok := ctx.Value(ginContextKey{}).(*gin.Context)
+	return ginCtx, ok
+}
+
+// Handle method adapts a typed handler to a gin.HandlerFunc: it binds Req like BindRequest and runs its Validate (if IValidatable), answering all
+// the invalid fields in a single http.StatusBadRequest, calls the handler, and writes exactly one response: the errors by ErrorStatus,
+// http.StatusCreated for POST, http.StatusNoContent for empty responses (a struct{} / NoContent Resp or a nil pointer), and http.StatusOK otherwise.
+// The handler ctx is the request context (cancelled with the request, carrying the principal, see auth.PrincipalFrom), and GinContext returns its gin.Context.
+// Nothing is written if the handler already responded (e.g. authz.Authorize).
+// Usage:
+//
+//	router.POST("/stores/:storeId/orders", ginutils.Handle(orderService.CreateOrder))
+//
+//	func (s *OrderService) CreateOrder(ctx context.Context, req CreateOrderRequest) (OrderDTO, error)
+func Handle[Req any, Resp any](handler func(ctx context.Context, req Req) (Resp, error)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		req, fields, decoded := bindRequest[Req](ctx)
+		if decoded { // Validate runs even if some fields failed, to report all the invalid fields at once
+			if err := validateRequest(&req); err != nil {
+				fields = appendFieldErrors(fields, validateFieldErrors(err)...)
+			}
+		}
+		if len(fields) > 0 {
+			ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while validating request", fields))
+			return
+		}
+
+		resp, err := handler(context.WithValue(ctx.Request.Context(), ginContextKey{}, ctx), req)
+		if ctx.Writer.Written() || ctx.IsAborted() {
+			return
+		}
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		if isEmptyResponse(resp) {
+			ctx.Status(http.StatusNoContent)
+			return
+		}
+		if ctx.Request.Method == http.MethodPost {
+			ctx.JSON(http.StatusCreated, resp)
+			return
+		}
+		ctx.JSON(http.StatusOK, resp)
+	}
+}
+
+func validateRequest(req interface{}) error {
+	if validatable, ok := req.(IValidatable); ok {
+		return validatable.Validate()
+	}
+	if validatable, ok := reflect.ValueOf(req).Elem().Interface().(IValidatable); ok {
+		return validatable.Validate()
+	}
+	return nil
+}
+
+func writeError(ctx *gin.Context, err error) {
+	status := ErrorStatus(err)
+	var fieldErrors FieldErrors
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &fieldErrors):
+		ctx.JSON(status, response.NewFieldsErrorResponse("Got error while validating request", validateFieldErrors(fieldErrors)))
+	case status >= http.StatusInternalServerError:
+		log.Errorf("Got error while handling %v %v: %v", ctx.Request.Method, ctx.FullPath(), err)
+		ctx.JSON(status, response.NewErrorResponse("Got error while handling request", errors.WithStack(err)))
+	case errors.As(err, &statusErr) && statusErr.Err != nil:
+		ctx.JSON(status, response.NewErrorResponse(statusErr.Message, statusErr.Err))
+	case errors.As(err, &statusErr):
+		ctx.JSON(status, response.NewErrorMessageResponse(statusErr.Message))
+	default:
+		ctx.JSON(status, response.NewErrorMessageResponse(err.Error()))
+	}
+}
+
+func isEmptyResponse(resp interface{}) bool {
+	value := reflect.ValueOf(resp)
+	if !value.IsValid() {
+		return true
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		return value.Type().NumField() == 0
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package ginutils
+
+import (
+	"context"
+	"github.com/let-commerce/backend-common/auth"
+	"github.com/let-commerce/backend-common/auth/authtest"
+	"net/http"
+	"testing"
+)
+
+func TestHandleMergesBindingAndValidateErrors(t *testing.T) {
+	handler := Handle(func(ctx context.Context, req bindTestRequest) (NoContent, error) {
+		t.Fatal("expected the handler not to be called")
+		return NoContent{}, nil
+	})
+	ctx, recorder := bindTestContext(http.MethodPost, "/orders/12", `{"note":"oops"}`, nil)
+	handler(ctx)
+
+	fields := errorFields(t, recorder)
+	if recorder.Code != http.StatusBadRequest || len(fields) != 2 || fields[0].Field != "from" || fields[1].Rule != "oops" {
+		t.Fatalf("expected a single 400 with the binding and Validate errors, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestHandlePassesRequestContext(t *testing.T) {
+	var handlerCtx context.Context
+	handler := Handle(func(ctx context.Context, req bindTestRequest) (NoContent, error) {
+		handlerCtx = ctx
+		return NoContent{}, nil
+	})
+	ctx, recorder := bindTestContext(http.MethodPost, "/orders/12?from=3/1/2022", "", nil)
+	requestCtx, cancel := context.WithCancel(ctx.Request.Context())
+	ctx.Request = ctx.Request.WithContext(requestCtx)
+	authtest.Middleware(authtest.Consumer(7))(ctx)
+	handler(ctx)
+
+	if ctx.Writer.Status() != http.StatusNoContent {
+		t.Fatalf("expected 204, got %v: %v", ctx.Writer.Status(), recorder.Body.String())
+	}
+	cancel()
+	if handlerCtx.Err() != context.Canceled {
+		t.Fatal("expected the handler ctx to be cancelled with the request")
+	}
+	if p, ok := auth.PrincipalFrom(handlerCtx); !ok || p.ConsumerID != 7 {
+		t.Fatal("expected the handler ctx to carry the principal")
+	}
+	if ginCtx, ok := GinContext(handlerCtx); !ok || ginCtx != ctx {
+		t.Fatal("expected the handler ctx to carry the gin context")
+	}
+}
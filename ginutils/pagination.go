@@ -0,0 +1,252 @@
+package ginutils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/env"
+	"github.com/let-commerce/backend-common/response"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageLimit   = 20
+	MaxPageLimit       = 100
+	MaxPage            = 1000 // deeper pages should use cursor pagination
+	CursorSecretEnvVar = "PAGINATION_CURSOR_SECRET"
+)
+
+// CursorSecret signs the pagination cursors, so clients can't forge or tamper with them.
+// Defaults to the PAGINATION_CURSOR_SECRET env var, read on use (so it can be loaded by env.InitEnvFile in main).
+var CursorSecret []byte
+
+// ErrCursorSort is returned by cursor paginated queries ordered by another column, the cursor only pages by its own column
+var ErrCursorSort = errors.New("cursor pagination can't be combined with another sort")
+
+// PaginationOptions configures the pagination of a list endpoint
+type PaginationOptions struct {
+	DefaultLimit int    // defaults to DefaultPageLimit
+	MaxLimit     int    // larger limits are reduced to it, defaults to MaxPageLimit
+	CursorColumn string // enables cursor (keyset) pagination on the given unique column (e.g. "id"), ordered by it, instead of page numbers
+}
+
+// PageRequest is the pagination of a list request, bound by GetPageRequest
+type PageRequest struct {
+	Limit   int
+	Page    int  // 1-based page number, for offset pagination
+	After   uint // the cursor column value of the last item of the previous page, for cursor pagination
+	options PaginationOptions
+}
+
+type cursorPayload struct {
+	After uint `json:"a"`
+}
+
+// GetPageRequest method binds the "limit" and "page" (or "cursor") query params from ctx and return http.StatusBadRequest if they are invalid
+// Usage:
+//
+//	pageRequest, err := ginutils.GetPageRequest(ctx, ginutils.PaginationOptions{CursorColumn: "id"})
+//	if err != nil {
+//		return
+//	}
+//	page, err := ginutils.FindPage(ctx, db.Model(&Order{}).Where("consumer_id = ?", consumerId), pageRequest, func(o Order) uint { return o.ID })
+func GetPageRequest(ctx *gin.Context, options PaginationOptions) (PageRequest, error) {
+	if options.DefaultLimit == 0 {
+		options.DefaultLimit = DefaultPageLimit
+	}
+	if options.MaxLimit == 0 {
+		options.MaxLimit = MaxPageLimit
+	}
+	p := PageRequest{Limit: options.DefaultLimit, Page: 1, options: options}
+
+	var fields []response.FieldError
+	if limit, exists, err := getPositiveQuery(ctx, "limit"); err != nil {
+		fields = append(fields, response.FieldError{Field: "limit", Rule: "min", Source: QuerySource, Message: err.Error()})
+	} else if exists {
+		p.Limit = limit
+		if p.Limit > options.MaxLimit {
+			p.Limit = options.MaxLimit
+		}
+	}
+	if options.CursorColumn != "" {
+		if sort, exists := GetStringQuery(ctx, "sort"); exists && sort != "" {
+			fields = append(fields, response.FieldError{Field: "sort", Rule: "cursor", Source: QuerySource, Message: "is not supported with cursor pagination"})
+		}
+		if cursor, exists := GetStringQuery(ctx, "cursor"); exists && cursor != "" {
+			after, err := parseCursor(ctx.Request.URL.Path, cursor)
+			if err != nil {
+				fields = append(fields, response.FieldError{Field: "cursor", Rule: "cursor", Source: QuerySource, Message: err.Error()})
+			}
+			p.After = after
+		}
+	} else if page, exists, err := getPositiveQuery(ctx, "page"); err != nil {
+		fields = append(fields, response.FieldError{Field: "page", Rule: "min", Source: QuerySource, Message: err.Error()})
+	} else if page > MaxPage {
+		fields = append(fields, response.FieldError{Field: "page", Rule: "max", Source: QuerySource, Message: fmt.Sprintf("must be at most %v", MaxPage)})
+	} else if exists {
+		p.Page = page
+	}
+
+	if len(fields) > 0 {
+		ctx.JSON(http.StatusBadRequest, response.NewFieldsErrorResponse("Got error while binding pagination", fields))
+		return PageRequest{}, FieldErrors(fields)
+	}
+	return p, nil
+}
+
+// IsCursor reports whether the request is paginated by cursor (keyset), instead of page numbers
+func (p PageRequest) IsCursor() bool {
+	return p.options.CursorColumn != ""
+}
+
+// Offset returns the number of items before the requested page, for offset pagination
+func (p PageRequest) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// Scope is a GORM scope applying the pagination to the query, cursor pagination also orders by the cursor column and fetches an extra item (to know if there is a next page).
+// Cursor paginated queries must not be ordered otherwise (ErrCursorSort), so the scope must come after the other scopes.
+// The default sort of a FilterQuery is replaced by the cursor order.
+// Usage: db.Scopes(filterQuery.Scope, pageRequest.Scope).Find(&orders)
+func (p PageRequest) Scope(db *gorm.DB) *gorm.DB {
+	if !p.IsCursor() {
+		return db.Offset(p.Offset()).Limit(p.Limit)
+	}
+	if _, ordered := db.Statement.Clauses["ORDER BY"]; ordered {
+		if _, isDefault := db.InstanceGet(defaultSortKey); !isDefault {
+			_ = db.AddError(ErrCursorSort)
+			return db
+		}
+		delete(db.Statement.Clauses, "ORDER BY")
+	}
+	column := clause.Column{Name: p.options.CursorColumn}
+	if p.After != 0 {
+		db = db.Where(clause.Gt{Column: column, Value: p.After})
+	}
+	return db.Order(clause.OrderByColumn{Column: column}).Limit(p.Limit + 1)
+}
+
+// FindPage method counts and finds the requested page of the query, and sets its Link header (rel "next", "prev" and "first").
+// The cursor func returns the cursor column value of an item, it's only used for cursor pagination.
+func FindPage[T any](ctx *gin.Context, query *gorm.DB, p PageRequest, cursor func(T) uint) (response.Page[T], error) {
+	page := response.Page[T]{Items: []T{}}
+	if err := query.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return response.Page[T]{}, errors.Wrap(err, "can't count page items")
+	}
+	if err := query.Session(&gorm.Session{}).Scopes(p.Scope).Find(&page.Items).Error; err != nil {
+		return response.Page[T]{}, errors.Wrap(err, "can't find page items")
+	}
+
+	links := map[string]url.Values{}
+	if p.IsCursor() {
+		links["first"] = pageQuery(ctx, "cursor", "")
+		if len(page.Items) > p.Limit {
+			page.Items = page.Items[:p.Limit]
+			next, err := signCursor(ctx.Request.URL.Path, cursor(page.Items[p.Limit-1]))
+			if err != nil {
+				return response.Page[T]{}, err
+			}
+			page.NextCursor = next
+			links["next"] = pageQuery(ctx, "cursor", next)
+		}
+	} else {
+		links["first"] = pageQuery(ctx, "page", "1")
+		if int64(p.Offset()+len(page.Items)) < page.Total && p.Page < MaxPage {
+			links["next"] = pageQuery(ctx, "page", strconv.Itoa(p.Page+1))
+		}
+		if p.Page > 1 {
+			links["prev"] = pageQuery(ctx, "page", strconv.Itoa(p.Page-1))
+		}
+	}
+	SetLinkHeader(ctx, links)
+	return page, nil
+}
+
+// SetLinkHeader sets the RFC 8288 Link header of the response, with a link (to the request path with the given query) per relation
+func SetLinkHeader(ctx *gin.Context, links map[string]url.Values) {
+	var values []string
+	for _, rel := range []string{"first", "prev", "next"} {
+		if query, ok := links[rel]; ok {
+			link := url.URL{Path: ctx.Request.URL.Path, RawQuery: query.Encode()}
+			values = append(values, fmt.Sprintf(`<%v>; rel="%v"`, link.String(), rel))
+		}
+	}
+	if len(values) > 0 {
+		ctx.Header("Link", strings.Join(values, ", "))
+	}
+}
+
+// pageQuery returns the request query, with the given pagination param (removed when empty)
+func pageQuery(ctx *gin.Context, param string, value string) url.Values {
+	query := ctx.Request.URL.Query()
+	if value == "" {
+		query.Del(param)
+	} else {
+		query.Set(param, value)
+	}
+	return query
+}
+
+func getPositiveQuery(ctx *gin.Context, paramName string) (int, bool, error) {
+	paramVal, exists := GetStringQuery(ctx, paramName)
+	if !exists {
+		return 0, false, nil
+	}
+	value, err := strconv.Atoi(paramVal)
+	if err != nil || value < 1 {
+		return 0, true, fmt.Errorf("must be a positive number (value = %v)", paramVal)
+	}
+	return value, true, nil
+}
+
+// signCursor returns an opaque cursor of the given value, signed for the given path (cursors of another list are rejected)
+func signCursor(path string, after uint) (string, error) {
+	if len(cursorSecret()) == 0 {
+		return "", errors.Errorf("pagination cursor secret is not configured (%v)", CursorSecretEnvVar)
+	}
+	payload, err := json.Marshal(cursorPayload{After: after})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(cursorSignature(path, encoded)), nil
+}
+
+func parseCursor(path string, cursor string) (uint, error) {
+	encoded, signature, found := strings.Cut(cursor, ".")
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if !found || err != nil || len(cursorSecret()) == 0 || !hmac.Equal(decodedSignature, cursorSignature(path, encoded)) {
+		return 0, errors.New("invalid cursor")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	var decoded cursorPayload
+	if err = json.Unmarshal(payload, &decoded); err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	return decoded.After, nil
+}
+
+func cursorSignature(path string, encoded string) []byte {
+	mac := hmac.New(sha256.New, cursorSecret())
+	mac.Write([]byte(path + "\n" + encoded))
+	return mac.Sum(nil)
+}
+
+func cursorSecret() []byte {
+	if len(CursorSecret) > 0 {
+		return CursorSecret
+	}
+	return []byte(env.GetEnvVar(CursorSecretEnvVar))
+}
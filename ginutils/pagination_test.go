@@ -0,0 +1,129 @@
+package ginutils
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func cursorTestContext(target string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return ctx, recorder
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	t.Setenv(CursorSecretEnvVar, "pagination-test-secret")
+	cursor, err := signCursor("/orders", 42)
+	if err != nil {
+		t.Fatalf("can't sign cursor: %v", err)
+	}
+	ctx, _ := cursorTestContext("/orders?cursor=" + cursor)
+	p, err := GetPageRequest(ctx, PaginationOptions{CursorColumn: "id"})
+	if err != nil || p.After != 42 {
+		t.Fatalf("expected the cursor to be read from the env secret, got %v, %v", p.After, err)
+	}
+}
+
+func TestCursorTampering(t *testing.T) {
+	t.Setenv(CursorSecretEnvVar, "pagination-test-secret")
+	cursor, err := signCursor("/orders", 42)
+	if err != nil {
+		t.Fatalf("can't sign cursor: %v", err)
+	}
+	forged, err := signCursor("/orders", 1000)
+	if err != nil {
+		t.Fatalf("can't sign cursor: %v", err)
+	}
+	encoded, signature, _ := strings.Cut(cursor, ".")
+	forgedEncoded, _, _ := strings.Cut(forged, ".")
+
+	tests := map[string]struct {
+		path   string
+		cursor string
+	}{
+		"tampered payload":  {"/orders", forgedEncoded + "." + signature},
+		"missing signature": {"/orders", encoded},
+		"another list":      {"/products", cursor},
+		"garbage":           {"/orders", "not-a-cursor"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseCursor(test.path, test.cursor); err == nil {
+				t.Fatal("expected the cursor to be rejected")
+			}
+		})
+	}
+
+	t.Setenv(CursorSecretEnvVar, "")
+	if _, err = parseCursor("/orders", cursor); err == nil {
+		t.Fatal("expected cursors to be rejected without a secret")
+	}
+	if _, err = signCursor("/orders", 42); err == nil {
+		t.Fatal("expected cursors not to be signed without a secret")
+	}
+}
+
+func TestCursorPaginationRejectsSorts(t *testing.T) {
+	ctx, recorder := cursorTestContext("/orders?sort=-createdAt")
+	if _, err := GetPageRequest(ctx, PaginationOptions{CursorColumn: "id"}); err == nil {
+		t.Fatal("expected a sort to be rejected in cursor pagination")
+	}
+	if fields := errorFields(t, recorder); recorder.Code != http.StatusBadRequest || len(fields) != 1 || fields[0].Field != "sort" {
+		t.Fatalf("expected a 400 on the sort field, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("can't open dry run db: %v", err)
+	}
+	p := PageRequest{Limit: 10, After: 42, options: PaginationOptions{CursorColumn: "id"}}
+	var items []struct{ ID uint }
+	sorted := db.Table("orders").Scopes(FilterQuery{Sorts: []Sort{{Column: "created_at", Desc: true}}}.Scope, p.Scope).Find(&items)
+	if !errors.Is(sorted.Error, ErrCursorSort) {
+		t.Fatalf("expected ErrCursorSort, got: %v", sorted.Error)
+	}
+	paged := db.Table("orders").Scopes(p.Scope).Find(&items)
+	if sql := paged.Statement.SQL.String(); paged.Error != nil || sql != `SELECT * FROM "orders" WHERE "id" > $1 ORDER BY "id" LIMIT 11` {
+		t.Fatalf("unexpected cursor query: %v, %v", sql, paged.Error)
+	}
+}
+
+func TestCursorPaginationReplacesDefaultSort(t *testing.T) {
+	type orderFilter struct {
+		Status    string    `filter:"status"`
+		CreatedAt time.Time `sort:"created_at"`
+	}
+	ctx, _ := cursorTestContext("/orders?filter[status]=paid")
+	filterQuery, err := GetFilterQuery(ctx, NewFilterSpec[orderFilter]("-created_at"))
+	if err != nil {
+		t.Fatalf("unexpected filter error: %v", err)
+	}
+	p, err := GetPageRequest(ctx, PaginationOptions{CursorColumn: "id"})
+	if err != nil {
+		t.Fatalf("unexpected pagination error: %v", err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("can't open dry run db: %v", err)
+	}
+	var items []struct{ ID uint }
+	paged := db.Table("orders").Scopes(filterQuery.Scope, p.Scope).Find(&items)
+	if sql := paged.Statement.SQL.String(); paged.Error != nil || sql != `SELECT * FROM "orders" WHERE "status" = $1 ORDER BY "id" LIMIT 21` {
+		t.Fatalf("expected the default sort to be replaced by the cursor order, got: %v, %v", sql, paged.Error)
+	}
+
+	offsetQuery := db.Table("orders").Scopes(filterQuery.Scope, PageRequest{Limit: 10, Page: 1}.Scope).Find(&items)
+	if sql := offsetQuery.Statement.SQL.String(); offsetQuery.Error != nil || !strings.Contains(sql, `ORDER BY "created_at" DESC`) {
+		t.Fatalf("expected the default sort in offset pagination, got: %v, %v", sql, offsetQuery.Error)
+	}
+}
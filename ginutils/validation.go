@@ -0,0 +1,74 @@
+package ginutils
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/let-commerce/backend-common/response"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+)
+
+// FieldErrors is an error holding all the invalid fields of a request, returned by the binding methods (after writing the http.StatusBadRequest).
+// IValidatable.Validate can return it to report several invalid fields at once.
+// Usage:
+//
+//	func (dto CreateOrderDTO) Validate() error {
+//		var errs ginutils.FieldErrors
+//		if dto.Quantity > dto.Stock {
+//			errs.Add("quantity", "stock", "exceeds the stock")
+//		}
+//		if dto.DeliveryDate.Before(time.Now()) {
+//			errs.Add("deliveryDate", "future", "must be in the future")
+//		}
+//		return errs.Err()
+//	}
+type FieldErrors []response.FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = strings.TrimSpace(field.Source+" "+field.Field) + ": " + field.Message
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// Add adds an invalid field, with the failed rule and a message for the client
+func (e *FieldErrors) Add(field string, rule string, message string) {
+	*e = append(*e, response.FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// Err returns the field errors as an error, nil if there are none
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// bindFieldErrors maps a body binding error (of the given struct type) to field errors
+func bindFieldErrors(structType reflect.Type, err error) []response.FieldError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return []response.FieldError{bodyFieldError(errors.Cause(err))}
+	}
+	for structType.Kind() == reflect.Pointer {
+		structType = structType.Elem()
+	}
+	return validationFieldErrors(structType, validationErrors)
+}
+
+// validateFieldErrors maps an IValidatable.Validate error to field errors, FieldErrors are kept as is (from the body, unless set otherwise)
+func validateFieldErrors(err error) []response.FieldError {
+	var fieldErrors FieldErrors
+	if !errors.As(err, &fieldErrors) {
+		return []response.FieldError{{Rule: "validation", Source: BodySource, Message: err.Error()}}
+	}
+	fields := make([]response.FieldError, len(fieldErrors))
+	for i, field := range fieldErrors {
+		if field.Source == "" {
+			field.Source = BodySource
+		}
+		fields[i] = field
+	}
+	return fields
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/let-commerce/backend-common/auth/principal"
 	requestid "github.com/let-commerce/backend-common/request-id"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -63,7 +64,11 @@ func (f *PlainFormatter) Format(entry *log.Entry) ([]byte, error) {
 	if ctx != nil {
 		requestId = requestid.GetRequestIDFromContext(ctx)
 	}
-	return []byte(fmt.Sprintf("[%s] [%s] - %s [%v:%v:%v - %v]\n", f.LevelDesc[entry.Level], timestamp, entry.Message, ServiceName, Env, requestId, Caller(entry.Caller))), nil
+	var data string
+	if len(entry.Data) > 0 {
+		data = fmt.Sprintf(" %v", entry.Data)
+	}
+	return []byte(fmt.Sprintf("[%s] [%s] - %s%s [%v:%v:%v - %v]\n", f.LevelDesc[entry.Level], timestamp, entry.Message, data, ServiceName, Env, requestId, Caller(entry.Caller))), nil
 }
 
 func Caller(f *runtime.Frame) string {
@@ -98,9 +103,12 @@ func (f *JsonFormatter) Format(entry *log.Entry) ([]byte, error) {
 	result["severity"] = f.LevelDesc[entry.Level]
 	result["serviceName"] = ServiceName
 	result["env"] = Env
+	if len(entry.Data) > 0 {
+		result["data"] = entry.Data
+	}
 
 	requestId := ""
-	var consumerId, backofficeUserId uint
+	var consumerId, backofficeUserId, impersonatorId uint
 	var isGuest, isAdmin bool
 	if ctx != nil {
 		requestId = requestid.GetRequestIDFromContext(ctx)
@@ -116,28 +124,10 @@ func (f *JsonFormatter) Format(entry *log.Entry) ([]byte, error) {
 		httpRequest["remoteIp"] = ctx.Request.RemoteAddr
 		result["httpRequest"] = httpRequest
 
-		if v, ok := ctx.Get("AUTHENTICATED_CONSUMER_ID"); ok {
-			if authenticatedConsumerId, ok := v.(uint); ok {
-				consumerId = authenticatedConsumerId
-			}
-		}
-
-		if v, ok := ctx.Get("IS_GUEST"); ok {
-			if isGuestR, ok := v.(bool); ok {
-				isGuest = isGuestR
-			}
-		}
-
-		if v, ok := ctx.Get("IS_ADMIN"); ok {
-			if isAdminR, ok := v.(bool); ok {
-				isAdmin = isAdminR
-			}
-		}
-
-		if v, ok := ctx.Get("AUTHENTICATED_BACKOFFICE_USER_ID"); ok {
-			if authenticatedBackofficeUserId, ok := v.(uint); ok {
-				backofficeUserId = authenticatedBackofficeUserId
-			}
+		if p, ok := principal.FromContext(ctx); ok {
+			consumerId, isGuest = p.ConsumerID, p.IsGuest
+			backofficeUserId, isAdmin = p.BackofficeUserID, p.IsAdmin
+			impersonatorId = p.ImpersonatorID
 		}
 	}
 	var consumer, backofficeUser, authInfo string
@@ -150,6 +140,10 @@ func (f *JsonFormatter) Format(entry *log.Entry) ([]byte, error) {
 	if consumerId != 0 || backofficeUserId != 0 {
 		authInfo = fmt.Sprintf(" [%v%v]", consumer, backofficeUser)
 	}
+	if impersonatorId != 0 {
+		result["impersonatedBy"] = impersonatorId
+		authInfo = fmt.Sprintf(" [IMPERSONATED ConsumerId:%v by BackofficeId:%v]", consumerId, impersonatorId)
+	}
 
 	result["message"] = fmt.Sprintf("%s [%v:%v:%v - %v]%v", entry.Message, ServiceName, Env, requestId, Caller(entry.Caller), authInfo)
 
@@ -0,0 +1,13 @@
+package logs
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// SecurityEventField is the log field holding the security event type, so events can be filtered / alerted on (e.g. jsonPayload.data.securityEvent)
+const SecurityEventField = "securityEvent"
+
+// SecurityEvent logs a structured security event (e.g. an auth lockout) with the given fields
+func SecurityEvent(eventType string, fields log.Fields) {
+	log.WithFields(fields).WithField(SecurityEventField, eventType).Warnf("Security event: %v", eventType)
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"github.com/let-commerce/backend-common/env"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 // for more operations: https://lzone.de/cheat-sheet/Redis
@@ -16,6 +17,18 @@ func RedisConnect() redis.Conn {
 	return conn
 }
 
+// RedisPool creates a pool of redis connections, safe for concurrent use (unlike a single redis.Conn)
+func RedisPool() *redis.Pool {
+	url := env.MustGetEnvVar("REDIS_URL")
+	return &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", url)
+		},
+	}
+}
+
 func SetValue(conn redis.Conn, key string, value interface{}) {
 	_, err := conn.Do("SET", key, value)
 	if err != nil {
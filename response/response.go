@@ -9,13 +9,29 @@ type Response struct {
 	ID      uint   `json:"id,omitempty" example:"1"` // the effected ID (if exists)
 }
 
+// Page is the envelope of a list endpoint page
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total" example:"42"`                     // the number of items in all the pages
+	NextCursor string `json:"nextCursor,omitempty" example:"eyJhIjo"` // the cursor of the next page (cursor pagination), empty on the last page
+}
+
 type StatusResponse struct {
 	Status string `json:"status" example:"OK"`
 }
 
 type ErrorResponse struct {
-	Message string `json:"message" example:"Error details"`
-	Error   string `json:"error,omitempty"`
+	Message string       `json:"message" example:"Error details"`
+	Error   string       `json:"error,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"` // the invalid request fields, for bad requests
+}
+
+// FieldError is a single invalid field of a request
+type FieldError struct {
+	Field   string `json:"field" example:"from"`
+	Rule    string `json:"rule" example:"type"`              // the failed rule, e.g. "required", "type" or a validator tag ("min", "email")
+	Source  string `json:"source,omitempty" example:"query"` // path, query, header or body
+	Message string `json:"message" example:"can't parse \"abc\" as a date"`
 }
 
 func NewResponse(message string, id uint) Response {
@@ -38,3 +54,7 @@ func NewErrorResponse(message string, err error) ErrorResponse {
 func NewErrorMessageResponse(message string) ErrorResponse {
 	return ErrorResponse{Message: message}
 }
+
+func NewFieldsErrorResponse(message string, fields []FieldError) ErrorResponse {
+	return ErrorResponse{Message: message, Errors: fields}
+}
@@ -1,6 +1,7 @@
 package encoders
 
 import (
+	"fmt"
 	"github.com/let-commerce/backend-common/env"
 	"github.com/speps/go-hashids/v2"
 )
@@ -22,6 +23,22 @@ func EncodeId(id uint) string {
 	return result
 }
 
+// DecodeIdWithError decodes an id encoded by EncodeId, returning an error (instead of panicking) for invalid ids
+func DecodeIdWithError(str string) (uint, error) {
+	hd := hashids.NewData()
+	hd.Salt = salt
+	hd.MinLength = 6
+	h, _ := hashids.NewWithData(hd)
+	numbers, err := h.DecodeWithError(str)
+	if err != nil {
+		return 0, err
+	}
+	if len(numbers) != 1 || numbers[0] < 0 {
+		return 0, fmt.Errorf("invalid encoded id: %v", str)
+	}
+	return uint(numbers[0]), nil
+}
+
 func DecodeId(str string) uint {
 	hd := hashids.NewData()
 	hd.Salt = salt